@@ -7,5 +7,10 @@ import (
 var Cmd = &cobra.Command{
 	Use:   "auth",
 	Short: "Authenticate with the Pathbird API",
-	Long:  "Authenticate with the Pathbird API.",
+	Long: "Authenticate with the Pathbird API.\n\n" +
+		"Credentials are stored in ~/.pathbird/auth.json by default. Set\n" +
+		"credentials_store in ~/.pathbird/config.toml (or PATHBIRD_CREDENTIALS_STORE)\n" +
+		"to \"encrypted-file\" to encrypt them with a passphrase (which can be supplied\n" +
+		"via PATHBIRD_CREDENTIALS_PASSPHRASE), or to \"keyring\" to use the system\n" +
+		"keyring.",
 }
@@ -1,21 +1,79 @@
 package auth
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"github.com/manifoldco/promptui"
 	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/graphql"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// flag vars
+var (
+	loginEmail    string
+	tokenStdin    bool
+	passwordStdin bool
+	webLogin      bool
 )
 
 var authLoginCmd = &cobra.Command{
 	Use:   "login",
 	Short: "Log in to Pathbird",
+	Long: "Log in to Pathbird.\n\n" +
+		"By default, this prompts for an email and password. For non-interactive\n" +
+		"environments (e.g., CI pipelines), use --token-stdin to read an API token\n" +
+		"from stdin, or --email with --password-stdin to read a password from stdin.\n" +
+		"Alternatively, set the " + auth.TokenEnvVar + " environment variable to skip\n" +
+		"logging in altogether.\n\n" +
+		"Use --web to log in through the Pathbird website instead (e.g., for SSO\n" +
+		"accounts that don't have a Pathbird password).",
 
 	RunE: func(cmd *cobra.Command, args []string) error {
-		credentials, err := authLoginPrompt()
-		if err != nil {
+		if countTrue(tokenStdin, passwordStdin, webLogin) > 1 {
+			return errors.New("--web, --token-stdin, and --password-stdin are mutually exclusive")
+		}
+		// Log in to the API host of the profile
+		if err := auth.ActivateProfile(); err != nil {
 			return err
 		}
+
+		if webLogin {
+			if _, err := loginWithBrowser(); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(os.Stderr, "Logged in successfully")
+			return nil
+		}
+
+		if tokenStdin {
+			return loginWithTokenStdin()
+		}
+
+		var credentials *authLoginPromptResult
+		if passwordStdin {
+			if loginEmail == "" {
+				return errors.New("--email must be specified when using --password-stdin")
+			}
+			password, err := readStdinSecret()
+			if err != nil {
+				return errors.Wrap(err, "failed to read password from stdin")
+			}
+			credentials = &authLoginPromptResult{email: loginEmail, password: password}
+		} else {
+			var err error
+			credentials, err = authLoginPrompt()
+			if err != nil {
+				return err
+			}
+		}
+
 		authResult, err := auth.AuthenticateWithPassword(credentials.email, credentials.password)
 		if err != nil {
 			return err
@@ -28,7 +86,144 @@ var authLoginCmd = &cobra.Command{
 }
 
 func init() {
+	authLoginCmd.Flags().StringVar(
+		&loginEmail,
+		"email",
+		"",
+		"the email to log in with (skips the email prompt)",
+	)
+	authLoginCmd.Flags().BoolVar(
+		&tokenStdin,
+		"token-stdin",
+		false,
+		"read an API token from stdin",
+	)
+	authLoginCmd.Flags().BoolVar(
+		&passwordStdin,
+		"password-stdin",
+		false,
+		"read the password from stdin (requires --email)",
+	)
+	authLoginCmd.Flags().BoolVar(
+		&webLogin,
+		"web",
+		false,
+		"log in using a web browser",
+	)
 	Cmd.AddCommand(authLoginCmd)
+	auth.SetReauthenticator(reauthenticate)
+}
+
+// Ask the user to log in again (used when the API rejects the stored
+// credentials in the middle of another command), the same way that they
+// logged in originally.
+func reauthenticate(loginMethod string) (*auth.Auth, error) {
+	_, _ = fmt.Fprintln(os.Stderr, "Your Pathbird session has expired or was revoked. Please log in again.")
+	switch loginMethod {
+	case auth.LoginMethodWeb:
+		return loginWithBrowser()
+	case auth.LoginMethodToken:
+		return reauthenticateWithTokenPrompt()
+	}
+
+	credentials, err := authLoginPrompt()
+	if err != nil {
+		return nil, err
+	}
+	authResult, err := auth.AuthenticateWithPassword(credentials.email, credentials.password)
+	if err != nil {
+		return nil, err
+	}
+	if authResult == nil {
+		return nil, errors.New("failed to authenticate")
+	}
+	return authResult, nil
+}
+
+func reauthenticateWithTokenPrompt() (*auth.Auth, error) {
+	tokenPrompt := promptui.Prompt{
+		Label: "API token",
+		Mask:  '*',
+	}
+	token, err := tokenPrompt.Run()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prompt api token")
+	}
+	token = strings.TrimSpace(token)
+	if _, err := verifyToken(token); err != nil {
+		return nil, err
+	}
+	return auth.AuthenticateWithToken(token)
+}
+
+func loginWithBrowser() (*auth.Auth, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	return auth.AuthenticateWithBrowser(ctx, func(loginURL string) {
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"Opening the Pathbird login page in your browser.\n"+
+				"If it doesn't open automatically, visit this URL:\n\n    %s\n\n",
+			loginURL,
+		)
+	})
+}
+
+func countTrue(bs ...bool) int {
+	n := 0
+	for _, b := range bs {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+func loginWithTokenStdin() error {
+	token, err := readStdinSecret()
+	if err != nil {
+		return errors.Wrap(err, "failed to read api token from stdin")
+	}
+	if token == "" {
+		return errors.New("no api token was provided on stdin")
+	}
+
+	user, err := verifyToken(token)
+	if err != nil {
+		return err
+	}
+	if _, err := auth.AuthenticateWithToken(token); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Logged in as %s\n", user.Email)
+	return nil
+}
+
+// Make sure that a token actually works before we save it.
+func verifyToken(token string) (*graphql.User, error) {
+	client := graphql.NewClient(&auth.Auth{ApiToken: token}).WithoutReauthentication()
+	user, err := client.QueryViewerUser(context.Background())
+	if err == graphql.ErrUnauthenticated {
+		return nil, errors.New("api token was rejected by the server")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to verify api token")
+	}
+	if user.ID == "" {
+		return nil, errors.New("api token was rejected by the server")
+	}
+	return user, nil
+}
+
+// Read a secret (token or password) from stdin.
+// Only the first line is used and surrounding whitespace is trimmed, which
+// makes it safe to use with `echo $TOKEN | pbauthor auth login --token-stdin`.
+func readStdinSecret() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimSpace(line), nil
 }
 
 type authLoginPromptResult struct {
@@ -37,12 +232,16 @@ type authLoginPromptResult struct {
 }
 
 func authLoginPrompt() (*authLoginPromptResult, error) {
-	emailPrompt := promptui.Prompt{
-		Label: "Email",
-	}
-	email, err := emailPrompt.Run()
-	if err != nil {
-		return nil, errors.Wrapf(err, "failed to prompt email")
+	email := loginEmail
+	if email == "" {
+		emailPrompt := promptui.Prompt{
+			Label: "Email",
+		}
+		var err error
+		email, err = emailPrompt.Run()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to prompt email")
+		}
 	}
 
 	passwordPrompt := promptui.Prompt{
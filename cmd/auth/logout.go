@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var authLogoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Log out of Pathbird",
+	Long: "Log out of Pathbird.\n\n" +
+		"This revokes the API token for the active profile and removes it from this\n" +
+		"machine.",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removed, err := auth.Logout()
+		if err != nil {
+			return err
+		}
+		if removed == nil {
+			_, _ = fmt.Fprintln(os.Stderr, "Not logged in")
+			return nil
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Logged out of %s (profile %q)\n", removed.Host, removed.Profile)
+		return nil
+	},
+}
+
+func init() {
+	Cmd.AddCommand(authLogoutCmd)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+var authProfilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage credential profiles",
+	Long: "Manage credential profiles.\n\n" +
+		"Each profile binds a Pathbird API host to the credentials that were issued by\n" +
+		"it, which makes it possible to stay logged in to multiple hosts (e.g., a\n" +
+		"staging and production server) at once. Select a profile for a single command\n" +
+		"with --profile (or PATHBIRD_PROFILE), or change the default profile with\n" +
+		"`pbauthor auth profiles use`.",
+}
+
+var authProfilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List credential profiles",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := auth.ListProfiles()
+		if err != nil {
+			return err
+		}
+		if len(profiles) == 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "No profiles (try running `pbauthor auth login`)")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "\tPROFILE\tHOST\tSTATUS")
+		for _, p := range profiles {
+			active := ""
+			if p.Active {
+				active = "*"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", active, p.Name, p.Host, profileStatus(&p))
+		}
+		return w.Flush()
+	},
+}
+
+var authProfilesUseCmd = &cobra.Command{
+	Use:   "use <profile>",
+	Short: "Set the default credential profile",
+	Long: "Set the default credential profile.\n\n" +
+		"If the profile doesn't exist, it's created and bound to the host given by\n" +
+		"--api-host (or the default Pathbird host).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		if err := auth.UseProfile(args[0]); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Now using profile %q\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	authProfilesCmd.AddCommand(authProfilesListCmd)
+	authProfilesCmd.AddCommand(authProfilesUseCmd)
+	Cmd.AddCommand(authProfilesCmd)
+}
+
+func profileStatus(p *auth.Profile) string {
+	switch {
+	case p.ApiToken == "":
+		return "logged out"
+	case p.IsExpired():
+		return "expired"
+	case p.Expiration.IsZero():
+		return "logged in (api token)"
+	default:
+		return fmt.Sprintf("logged in (until %s)", p.Expiration.Format(time.RFC1123))
+	}
+}
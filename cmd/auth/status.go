@@ -1,8 +1,10 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/graphql"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"time"
@@ -11,6 +13,10 @@ import (
 var authStatusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check authentication status",
+	Long: "Check authentication status.\n\n" +
+		"The stored credentials are verified against the Pathbird API. The command\n" +
+		"exits with a non-zero status if not authenticated or if the API rejects the\n" +
+		"credentials.",
 
 	RunE: func(cmd *cobra.Command, args []string) error {
 		auth, err := auth.GetAuth()
@@ -21,7 +27,37 @@ var authStatusCmd = &cobra.Command{
 			return errors.New("not authenticated")
 		}
 
-		fmt.Printf("✅ Authenticated (until %s)\n", auth.Expiration.Format(time.RFC1123))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		// Report rejected credentials (rather than asking to log in again)
+		client := graphql.NewClient(auth).WithoutReauthentication()
+		user, err := client.QueryViewerUser(ctx)
+		if err != nil && err != graphql.ErrUnauthenticated {
+			return errors.Wrap(err, "failed to verify credentials")
+		}
+		if err == graphql.ErrUnauthenticated || user.ID == "" {
+			return errors.Errorf(
+				"credentials were rejected by %s (they may have expired or been revoked; try running `pbauthor auth login`)",
+				auth.Host,
+			)
+		}
+		courses, err := client.QueryCourses(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to query courses")
+		}
+
+		fmt.Printf("✅ Authenticated as %s <%s>\n", user.Name, user.Email)
+		fmt.Printf("   Host:    %s\n", auth.Host)
+		fmt.Printf("   Profile: %s\n", auth.Profile)
+		fmt.Printf("   Token:   %s\n", describeExpiration(auth.Expiration))
+		if len(courses) == 0 {
+			fmt.Println("   Courses: (none)")
+		} else {
+			fmt.Println("   Courses:")
+			for _, c := range courses {
+				fmt.Printf("     - %s\n", c.Course.Name)
+			}
+		}
 		return nil
 	},
 }
@@ -29,3 +65,15 @@ var authStatusCmd = &cobra.Command{
 func init() {
 	Cmd.AddCommand(authStatusCmd)
 }
+
+func describeExpiration(expiration time.Time) string {
+	if expiration.IsZero() {
+		return "api token (no expiration)"
+	}
+	remaining := time.Until(expiration).Round(time.Minute)
+	return fmt.Sprintf(
+		"expires in %s (%s)",
+		remaining,
+		expiration.Format(time.RFC1123),
+	)
+}
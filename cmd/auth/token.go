@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/graphql"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// flag vars
+var (
+	tokenScopes    []string
+	tokenExpiresIn time.Duration
+)
+
+var authTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage long-lived API keys",
+	Long: "Manage long-lived API keys.\n\n" +
+		"API keys are meant for automation (e.g., scheduled course publishing from a\n" +
+		"CI pipeline). Unlike the credentials created by `pbauthor auth login`, they\n" +
+		"can be scoped, named, and revoked individually. To use an API key, set the\n" +
+		auth.TokenEnvVar + " environment variable or pipe it to\n" +
+		"`pbauthor auth login --token-stdin`.",
+}
+
+var authTokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a new API key",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		client, err := newAuthenticatedGraphQLClient()
+		if err != nil {
+			return err
+		}
+
+		input := &graphql.CreateApiKeyInput{
+			Name:   args[0],
+			Scopes: tokenScopes,
+		}
+		if tokenExpiresIn > 0 {
+			input.ExpiresAt = time.Now().Add(tokenExpiresIn).UTC().Format(time.RFC3339)
+		}
+		key, token, err := client.CreateApiKey(context.Background(), input)
+		if err != nil {
+			return errors.Wrap(err, "failed to create api key")
+		}
+
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"Created api key %q (id: %s). Store the token somewhere safe, it won't be shown again:\n",
+			key.Name, key.ID,
+		)
+		// Only the token goes to stdout so that it can be captured by scripts
+		fmt.Println(token)
+		return nil
+	},
+}
+
+var authTokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newAuthenticatedGraphQLClient()
+		if err != nil {
+			return err
+		}
+		keys, err := client.QueryApiKeys(context.Background())
+		if err != nil {
+			return errors.Wrap(err, "failed to list api keys")
+		}
+		if len(keys) == 0 {
+			_, _ = fmt.Fprintln(os.Stderr, "No api keys")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tLAST USED")
+		for _, k := range keys {
+			_, _ = fmt.Fprintf(
+				w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				k.ID,
+				k.Name,
+				orDefault(strings.Join(k.Scopes, ","), "(all)"),
+				formatApiDateTime(k.CreatedAt, "-"),
+				formatApiDateTime(k.ExpiresAt, "never"),
+				formatApiDateTime(k.LastUsedAt, "never"),
+			)
+		}
+		return w.Flush()
+	},
+}
+
+var authTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <id>",
+	Short: "Revoke an API key",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		client, err := newAuthenticatedGraphQLClient()
+		if err != nil {
+			return err
+		}
+		if err := client.RevokeApiKey(context.Background(), args[0]); err != nil {
+			return errors.Wrap(err, "failed to revoke api key")
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "Revoked api key %s\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	authTokenCreateCmd.Flags().StringArrayVar(
+		&tokenScopes,
+		"scope",
+		[]string{},
+		"restrict the api key to a scope (e.g., codex:upload); may be repeated",
+	)
+	authTokenCreateCmd.Flags().DurationVar(
+		&tokenExpiresIn,
+		"expires-in",
+		0,
+		"expire the api key after this duration (e.g., 2160h); by default, it never expires",
+	)
+	authTokenCmd.AddCommand(authTokenCreateCmd)
+	authTokenCmd.AddCommand(authTokenListCmd)
+	authTokenCmd.AddCommand(authTokenRevokeCmd)
+	Cmd.AddCommand(authTokenCmd)
+}
+
+func newAuthenticatedGraphQLClient() (*graphql.Client, error) {
+	a, err := auth.GetAuth()
+	if err != nil {
+		return nil, err
+	}
+	if a == nil {
+		return nil, errors.New("not authenticated")
+	}
+	return graphql.NewClient(a), nil
+}
+
+func formatApiDateTime(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	t, err := api.ParseDateTime(value)
+	if err != nil {
+		return value
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
+
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,175 @@
+package codex
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var codexConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with codex configuration files",
+}
+
+var codexConfigValidateCmd = &cobra.Command{
+	Use:   "validate [<path>]",
+	Short: "check a codex configuration file for errors",
+	Long: "Check a codex configuration file for errors.\n\n" +
+		"The path may be either a codex directory or a codex.toml file (defaults to\n" +
+		"the current directory).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			args = append(args, ".")
+		}
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+
+		file, err := resolveConfigFile(args[0])
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read codex config file (%s)", file)
+		}
+
+		diagnostics := codex.ValidateConfigInDir(data, filepath.Dir(file))
+		if len(diagnostics) == 0 {
+			fmt.Println(successf("%s is valid", file))
+			return nil
+		}
+		printDiagnostics(file, diagnostics)
+		os.Exit(1)
+		return nil
+	},
+}
+
+var codexConfigGetCmd = &cobra.Command{
+	Use:   "get <key> [<path>]",
+	Short: "print the value of a codex configuration key",
+	Long: "Print the value of a codex configuration key (e.g., upload.name).\n\n" +
+		"The path may be either a codex directory or a codex.toml file (defaults to\n" +
+		"the current directory).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return cmd.Usage()
+		}
+		file, err := resolveConfigFile(pathArg(args, 1))
+		if err != nil {
+			return err
+		}
+		value, set, err := codex.GetConfigValue(file, args[0])
+		if err != nil {
+			return err
+		}
+		if !set {
+			return errors.Errorf("%s is not set", args[0])
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var codexConfigSetCmd = &cobra.Command{
+	Use:   "set <key> <value> [<path>]",
+	Short: "set a codex configuration key",
+	Long: "Set a codex configuration key (e.g., upload.name).\n\n" +
+		"Comments and formatting in codex.toml are preserved. Arrays can be given\n" +
+		"either as a TOML array or as a comma-separated list, e.g.:\n\n" +
+		"    pbauthor codex config set kernel.system_packages make,texlive-latex-base\n\n" +
+		"The path may be either a codex directory or a codex.toml file (defaults to\n" +
+		"the current directory).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 2 || len(args) > 3 {
+			return cmd.Usage()
+		}
+		file, err := resolveConfigFile(pathArg(args, 2))
+		if err != nil {
+			return err
+		}
+		return handleConfigEditError(file, codex.SetConfigValue(file, args[0], args[1]))
+	},
+}
+
+var codexConfigUnsetCmd = &cobra.Command{
+	Use:   "unset <key> [<path>]",
+	Short: "remove a codex configuration key",
+	Long: "Remove a codex configuration key (e.g., kernel.image).\n\n" +
+		"Comments and formatting in codex.toml are preserved.\n\n" +
+		"The path may be either a codex directory or a codex.toml file (defaults to\n" +
+		"the current directory).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) < 1 || len(args) > 2 {
+			return cmd.Usage()
+		}
+		file, err := resolveConfigFile(pathArg(args, 1))
+		if err != nil {
+			return err
+		}
+		return handleConfigEditError(file, codex.UnsetConfigValue(file, args[0]))
+	},
+}
+
+func init() {
+	codexConfigCmd.AddCommand(codexConfigValidateCmd)
+	codexConfigCmd.AddCommand(codexConfigGetCmd)
+	codexConfigCmd.AddCommand(codexConfigSetCmd)
+	codexConfigCmd.AddCommand(codexConfigUnsetCmd)
+	Cmd.AddCommand(codexConfigCmd)
+}
+
+// Resolve a path given on the command line to a codex config file (the path
+// may be either the codex directory or the config file itself).
+func resolveConfigFile(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid path")
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid path")
+	}
+	if stat.IsDir() {
+		path = filepath.Join(path, codex.ConfigFileName)
+	}
+	return path, nil
+}
+
+func printDiagnostics(file string, diagnostics []codex.Diagnostic) {
+	_, _ = fmt.Fprintf(os.Stderr, "Invalid codex config (%d issues):\n", len(diagnostics))
+	for _, d := range diagnostics {
+		location := file
+		if d.Line != 0 {
+			location = fmt.Sprintf("%s:%d:%d", file, d.Line, d.Column)
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "- %s: %s\n", cyan(location), failf(d.Message))
+	}
+}
+
+// Get the (optional) path argument at the given index (defaults to the current
+// directory).
+func pathArg(args []string, i int) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return "."
+}
+
+// Edits that would make the config invalid are rejected (and the file is left
+// unchanged).
+func handleConfigEditError(file string, err error) error {
+	if validationErr, ok := err.(*codex.ValidationError); ok {
+		printDiagnostics(file, validationErr.Diagnostics)
+		os.Exit(1)
+	}
+	return err
+}
@@ -0,0 +1,128 @@
+package codex
+
+import (
+	"context"
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pathbird/pbauthor/internal/graphql"
+	"github.com/pathbird/pbauthor/internal/progress"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// Print what would be uploaded by `pbauthor codex upload` (without uploading
+// anything). Returns errUploadRejected if the upload would be rejected (which
+// has already been reported).
+func dryRunUpload(opts *codex.UploadCodexOptions) error {
+	// Use the upload limits from the API if we're logged in
+	var client *api.Client
+	if authn, err := auth.GetAuth(); err == nil && authn != nil {
+		client = api.New(authn.ApiToken)
+	}
+	// The config is validated exactly like it is for uploads
+	plan, err := codex.PlanUpload(client, opts)
+	if validationErr, ok := err.(*codex.ValidationError); ok {
+		printDiagnostics(validationErr.File, validationErr.Diagnostics)
+		return errUploadRejected
+	}
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if opts.Env != "" {
+		_, _ = fmt.Fprintf(w, "Environment:\t%s\n", opts.Env)
+	}
+	_, _ = fmt.Fprintf(w, "Codex config:\tOK (%s)\n", filepath.Join(opts.Dir, codex.ConfigFileName))
+	courseName, categoryName, codexName := describeUploadTarget(plan.Config)
+	_, _ = fmt.Fprintf(w, "Course:\t%s\n", courseName)
+	_, _ = fmt.Fprintf(w, "Codex category:\t%s\n", categoryName)
+	if plan.Request.CodexId != "" {
+		_, _ = fmt.Fprintf(w, "Mode:\t%s\n", warnf("replace existing codex %s", codexName))
+	} else {
+		_, _ = fmt.Fprintf(w, "Mode:\tupload new codex\n")
+	}
+	_, _ = fmt.Fprintf(
+		w,
+		"Notebook:\t%s (%s)\n",
+		cyan(plan.CodexFile.Name),
+		progress.FormatBytes(plan.CodexFile.Size),
+	)
+	_, _ = fmt.Fprintln(w)
+
+	_, _ = fmt.Fprintln(w, "FILE\tSIZE")
+	for _, f := range plan.TarFiles {
+		_, _ = fmt.Fprintf(w, "%s\t%s\n", f.Name, progress.FormatBytes(f.Size))
+	}
+	_, _ = fmt.Fprintln(w)
+
+	_, _ = fmt.Fprintf(w, "Files:\t%d (%s as tar)\n", len(plan.TarFiles), progress.FormatBytes(plan.TarSize))
+	if unchanged := countOmitted(plan.Request); unchanged > 0 {
+		_, _ = fmt.Fprintf(w, "Unchanged:\t%d (not sent, use --full to send them anyway)\n", unchanged)
+	}
+	_, _ = fmt.Fprintf(w, "Tar SHA-256:\t%s\n", plan.TarSha256)
+	if compression := plan.Request.Compression; compression != "" && compression != api.CompressionNone {
+		_, _ = fmt.Fprintf(w, "Compression:\t%s\n", compression)
+	}
+	_, _ = fmt.Fprintf(w, "Total payload:\t%s\n", progress.FormatBytes(plan.TotalSize))
+	if !plan.Preflight.Exceeded() {
+		_, _ = fmt.Fprintf(w, "Upload limits:\tOK (%s)\n", plan.Preflight.LimitsSource)
+		for _, unchecked := range plan.Preflight.Unchecked {
+			_, _ = fmt.Fprintf(w, "\t%s\n", warnf(unchecked))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if plan.Preflight.Exceeded() {
+		_, _ = fmt.Fprintln(os.Stderr)
+		printPreflightReport(plan.Preflight)
+		_, _ = fmt.Fprintln(os.Stderr)
+	}
+
+	fmt.Println(successf("Dry run: nothing was uploaded."))
+	if plan.Preflight.Exceeded() {
+		return errUploadRejected
+	}
+	return nil
+}
+
+// Count the files that are omitted from the upload (because they haven't
+// changed since the last upload).
+func countOmitted(req *api.UploadCodexRequest) int {
+	n := 0
+	for _, e := range req.Manifest {
+		if e.Omitted {
+			n++
+		}
+	}
+	return n
+}
+
+// Get the names of the course, codex category, and existing codex (falling back
+// to the IDs if they can't be determined).
+func describeUploadTarget(config *codex.Config) (courseName, categoryName, codexName string) {
+	courseName, categoryName, codexName = "(unknown)", config.Upload.CodexCategory, config.Upload.CodexId
+	authn, err := auth.GetAuth()
+	if err != nil || authn == nil {
+		log.Debug("not authenticated, so can't look up the upload target")
+		return courseName + " (not logged in)", categoryName, codexName
+	}
+	target, err := codex.ResolveUploadTarget(context.Background(), graphql.NewClient(authn), config)
+	if err != nil {
+		log.WithError(err).Warn("failed to look up the course and codex category")
+		return courseName, categoryName, codexName
+	}
+	if target.CodexCategory != nil {
+		courseName = fmt.Sprintf("%s (%s)", target.Course.Name, target.Course.ID)
+		categoryName = fmt.Sprintf("%s (%s)", target.CodexCategory.Name, target.CodexCategory.ID)
+	}
+	if target.CodexName != "" {
+		codexName = fmt.Sprintf("%q (%s)", target.CodexName, target.CodexId)
+	}
+	return courseName, categoryName, codexName
+}
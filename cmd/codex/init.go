@@ -11,6 +11,8 @@ import (
 var codexInitConfig struct {
 	systemPackages []string
 	codexName      string
+	course         string
+	codexCategory  string
 }
 
 var codexInitCmd = &cobra.Command{
@@ -40,7 +42,10 @@ var codexInitCmd = &cobra.Command{
 			return errors.New("not authenticated")
 		}
 
-		config, err := codex.InitConfig(dir)
+		config, err := codex.InitConfig(dir, &codex.InitOptions{
+			Course:        codexInitConfig.course,
+			CodexCategory: codexInitConfig.codexCategory,
+		})
 		if err != nil {
 			return errors.Wrap(err, "failed to initialize codex config")
 		}
@@ -73,5 +78,17 @@ func init() {
 		"",
 		"the name of the codex as displayed in the Pathbird UI",
 	)
+	codexInitCmd.Flags().StringVar(
+		&codexInitConfig.course,
+		"course",
+		"",
+		"the ID of the course to upload the codex to (default: the default_course setting)",
+	)
+	codexInitCmd.Flags().StringVar(
+		&codexInitConfig.codexCategory,
+		"codex-category",
+		"",
+		"the ID of the codex category to upload the codex to (default: the default_codex_category setting)",
+	)
 	Cmd.AddCommand(codexInitCmd)
 }
@@ -0,0 +1,50 @@
+package codex
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pathbird/pbauthor/internal/progress"
+	"os"
+	"text/tabwriter"
+)
+
+// The number of files to show when a codex exceeds the upload limits.
+const largestFilesShown = 10
+
+// Explain which upload limits a codex exceeds (and which files are the largest,
+// since those are usually the ones that should be excluded).
+func printPreflightReport(report *codex.PreflightReport) {
+	_, _ = fmt.Fprintf(
+		os.Stderr,
+		"%s (%s):\n",
+		failf("Codex exceeds the upload limits"),
+		report.LimitsSource,
+	)
+	for _, problem := range report.Problems {
+		_, _ = fmt.Fprintf(os.Stderr, "- %s\n", problem)
+	}
+	for _, unchecked := range report.Unchecked {
+		_, _ = fmt.Fprintf(os.Stderr, "- %s\n", faint(unchecked))
+	}
+
+	_, _ = fmt.Fprintln(os.Stderr, "\nLargest files:")
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	for _, f := range report.LargestFiles(largestFilesShown) {
+		size := progress.FormatBytes(f.Size)
+		if report.IsTooLarge(f) {
+			size = failf("%s (too large)", size)
+		}
+		_, _ = fmt.Fprintf(w, "  %s\t%s\n", f.Name, size)
+	}
+	if len(report.Files) > largestFilesShown {
+		_, _ = fmt.Fprintf(w, "  %s\n", faint(fmt.Sprintf("(and %d more)", len(report.Files)-largestFilesShown)))
+	}
+	_ = w.Flush()
+
+	_, _ = fmt.Fprintf(
+		os.Stderr,
+		"\nExclude files with upload.exclude in %s or a %s file.\n",
+		codex.ConfigFileName,
+		codex.IgnoreFileName,
+	)
+}
@@ -7,13 +7,17 @@ import (
 	"github.com/pathbird/pbauthor/internal/api"
 	"github.com/pathbird/pbauthor/internal/auth"
 	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pathbird/pbauthor/internal/config"
 	"github.com/pathbird/pbauthor/internal/graphql"
+	"github.com/pathbird/pbauthor/internal/progress"
 	"github.com/pathbird/pbauthor/internal/prompt"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
 	"path/filepath"
+	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -21,6 +25,14 @@ import (
 var (
 	skipConfirmation bool
 	noWait           bool
+	waitTimeout      time.Duration
+	env              string
+	dryRun           bool
+	resumable        bool
+	fullUpload       bool
+	compression      string
+	watch            bool
+	watchDebounce    time.Duration
 )
 
 var codexUploadCmd = &cobra.Command{
@@ -36,6 +48,22 @@ var codexUploadCmd = &cobra.Command{
 			return errors.Wrap(err, "invalid codex directory")
 		}
 
+		if dryRun {
+			if watch {
+				return errors.New("--watch and --dry-run can't be used together")
+			}
+			err := dryRunUpload(&codex.UploadCodexOptions{
+				Dir:         dir,
+				Env:         env,
+				Compression: compression,
+				Full:        fullUpload,
+			})
+			if err == errUploadRejected {
+				os.Exit(1)
+			}
+			return err
+		}
+
 		auth, err := auth.GetAuth()
 		if err != nil {
 			return err
@@ -44,83 +72,135 @@ var codexUploadCmd = &cobra.Command{
 			return errors.New("not authenticated")
 		}
 
-		// This is a placeholder for now, but in the future, we'd like to confirm
-		// the name of the codex and the course it's being uploaded to.
+		client := api.New(auth.ApiToken)
+		graphqlClient := graphql.NewClient(auth)
+		opts := &codex.UploadCodexOptions{
+			Dir:         dir,
+			Env:         env,
+			GraphQL:     graphqlClient,
+			Resumable:   resumable,
+			Full:        fullUpload,
+			Compression: compression,
+		}
 		if !skipConfirmation {
-			if !prompt.Confirm("Upload codex?") {
-				_, _ = fmt.Fprintln(os.Stderr, failf("Upload aborted."))
-				os.Exit(1)
+			opts.Confirm = confirmUploadTarget
+		}
+		if watch {
+			// Waiting for each kernel build would delay picking up the changes
+			// that are made in the meantime (and the build status is shown on
+			// the codex details page anyway)
+			noWait = true
+		}
+		if !noWait && !cmd.Flags().Changed("wait-timeout") {
+			userConfig, err := config.GetUserConfig()
+			if err != nil {
+				return err
 			}
+			waitTimeout = userConfig.GetUploadWaitTimeout()
 		}
 
-		client := api.New(auth.ApiToken)
-		res, parseErr, err := codex.UploadCodex(client, &codex.UploadCodexOptions{
-			Dir: dir,
-		})
-		if err != nil {
-			return err
+		if watch {
+			return watchAndUpload(client, graphqlClient, opts)
 		}
-		if parseErr != nil {
-			_, _ = fmt.Fprintf(
-				os.Stderr,
-				"Failed to parse codex (%d issues):\n",
-				len(parseErr.Errors),
-			)
-			for _, e := range parseErr.Errors {
-				_, _ = fmt.Fprintf(os.Stderr, "- %s\n  (%s", failf(e.Message), blue(e.Error))
-				if e.SourcePosition != "" {
-					_, _ = fmt.Fprintf(os.Stderr, " at %s", cyan(e.SourcePosition))
-				}
+		err = uploadAndReport(client, graphqlClient, opts)
+		if err == errUploadRejected {
+			os.Exit(1)
+		}
+		return err
+	},
+}
 
-				_, _ = fmt.Fprintf(os.Stderr, ")\n")
+// errUploadRejected is returned by uploadAndReport (or dryRunUpload) if the
+// codex couldn't be uploaded because of a problem with the codex itself (e.g.,
+// parse errors), which has already been reported.
+var errUploadRejected = errors.New("codex upload rejected")
 
-				for _, line := range e.SourceInfo.SourceContext.Lines {
-					_, _ = fmt.Fprintf(os.Stderr, "  %s %s\n", faint(">"), line)
-				}
+// Upload the codex, report any problems with it, and wait for the kernel build
+// to complete (unless --no-wait was set).
+func uploadAndReport(
+	client *api.Client,
+	graphqlClient *graphql.Client,
+	opts *codex.UploadCodexOptions,
+) error {
+	uploadProgress := progress.New("Uploading")
+	opts.Progress = uploadProgress.Update
+	res, parseErr, err := codex.UploadCodex(client, opts)
+	uploadProgress.Done()
+	if err == codex.ErrUploadAborted {
+		_, _ = fmt.Fprintln(os.Stderr, failf("Upload aborted."))
+		return errUploadRejected
+	}
+	if validationErr, ok := err.(*codex.ValidationError); ok {
+		printDiagnostics(validationErr.File, validationErr.Diagnostics)
+		return errUploadRejected
+	}
+	if limitsErr, ok := err.(*codex.UploadLimitsError); ok {
+		printPreflightReport(limitsErr.Report)
+		return errUploadRejected
+	}
+	if err != nil {
+		return err
+	}
+	if parseErr != nil {
+		_, _ = fmt.Fprintf(
+			os.Stderr,
+			"Failed to parse codex (%d issues):\n",
+			len(parseErr.Errors),
+		)
+		for _, e := range parseErr.Errors {
+			_, _ = fmt.Fprintf(os.Stderr, "- %s\n  (%s", failf(e.Message), blue(e.Error))
+			if e.SourcePosition != "" {
+				_, _ = fmt.Fprintf(os.Stderr, " at %s", cyan(e.SourcePosition))
+			}
+
+			_, _ = fmt.Fprintf(os.Stderr, ")\n")
+
+			for _, line := range e.SourceInfo.SourceContext.Lines {
+				_, _ = fmt.Fprintf(os.Stderr, "  %s %s\n", faint(">"), line)
 			}
-			os.Exit(1)
 		}
+		return errUploadRejected
+	}
 
-		detailsUrl := fmt.Sprintf("https://pathbird.com/codex/%s/details", res.CodexId)
-
-		if !noWait {
-			start := time.Now()
-			timeoutCtx, cancel := context.WithTimeout(context.Background(), 20*time.Minute)
-			defer cancel()
-			log.Info("waiting for kernel build to complete (this may take a while, please be patient!)...")
-			kernelStatus, err := codex.WaitForKernelBuildCompleted(
-				timeoutCtx,
-				graphql.NewClient(auth),
-				res.CodexId,
+	detailsUrl := codexDetailsUrl(res.CodexId)
+
+	if !noWait {
+		start := time.Now()
+		timeoutCtx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+		defer cancel()
+		log.Info("waiting for kernel build to complete (this may take a while, please be patient!)...")
+		kernelStatus, err := codex.WaitForKernelBuildCompleted(
+			timeoutCtx,
+			graphqlClient,
+			res.CodexId,
+		)
+		if err != nil {
+			log.WithError(
+				err,
+			).Error(
+				"Something went wrong while trying to check the status of the codex.",
 			)
-			if err != nil {
-				log.WithError(
-					err,
-				).Error(
-					"Something went wrong while trying to check the status of the codex.",
-				)
-				return err
-			}
-			log.Infof("waited %s for kernel build process", time.Since(start))
-			if kernelStatus.BuildStatus != "built" {
-				_, _ = fmt.Fprint(os.Stderr, failf(
-					"Failed to build kernel (got status: %s): %s\n",
-					kernelStatus.BuildStatus,
-					detailsUrl,
-				))
-				return errors.Errorf(
-					"failed to build kernel (got status: %s)",
-					kernelStatus.BuildStatus,
-				)
-			}
-		} else {
-			log.Info("not waiting for kernel build to complete (--no-wait was set)")
+			return err
 		}
+		log.Infof("waited %s for kernel build process", time.Since(start))
+		if kernelStatus.BuildStatus != "built" {
+			_, _ = fmt.Fprint(os.Stderr, failf(
+				"Failed to build kernel (got status: %s): %s\n",
+				kernelStatus.BuildStatus,
+				detailsUrl,
+			))
+			return errors.Errorf(
+				"failed to build kernel (got status: %s)",
+				kernelStatus.BuildStatus,
+			)
+		}
+	} else {
+		log.Info("not waiting for kernel build to complete (--no-wait was set)")
+	}
 
-		fmt.Println(successf("Successfully uploaded codex: %s", detailsUrl))
+	fmt.Println(successf("Successfully uploaded codex: %s", detailsUrl))
 
-		return nil
-	},
+	return nil
 }
 
 func init() {
@@ -137,11 +217,105 @@ func init() {
 		false,
 		"don't wait for the kernel build process to complete",
 	)
+	codexUploadCmd.Flags().DurationVar(
+		&waitTimeout,
+		"wait-timeout",
+		0,
+		"how long to wait for the kernel build process to complete (default: the upload_wait_timeout setting)",
+	)
+	codexUploadCmd.Flags().StringVar(
+		&env,
+		"env",
+		"",
+		"the environment to upload to (as defined by an [env.<name>] table in codex.toml)",
+	)
+	codexUploadCmd.Flags().BoolVar(
+		&dryRun,
+		"dry-run",
+		false,
+		"show what would be uploaded (without uploading anything)",
+	)
+	codexUploadCmd.Flags().BoolVar(
+		&resumable,
+		"resumable",
+		false,
+		"upload the codex in chunks (if the upload is interrupted, it's resumed the next time the same codex is uploaded)",
+	)
+	codexUploadCmd.Flags().BoolVar(
+		&fullUpload,
+		"full",
+		false,
+		"upload all files (even the ones that haven't changed since the last upload)",
+	)
+	codexUploadCmd.Flags().StringVar(
+		&compression,
+		"compression",
+		"",
+		"how to compress the uploaded files: "+strings.Join(api.Compressions, " or ")+" (default: the upload.compression setting)",
+	)
+	codexUploadCmd.Flags().BoolVar(
+		&watch,
+		"watch",
+		false,
+		"keep watching the codex directory and upload the codex again whenever a file changes (implies --no-wait)",
+	)
+	codexUploadCmd.Flags().DurationVar(
+		&watchDebounce,
+		"watch-debounce",
+		defaultWatchDebounce,
+		"with --watch, how long to wait for changes to settle before uploading",
+	)
 	Cmd.AddCommand(codexUploadCmd)
 }
 
+// Show the author where the codex is going to be uploaded (and, in
+// particular, whether an existing codex is going to be replaced) before
+// uploading anything.
+func confirmUploadTarget(target *codex.UploadTarget) bool {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	if env != "" {
+		_, _ = fmt.Fprintf(w, "  Environment:\t%s\n", env)
+	}
+	if target.CodexCategory != nil {
+		_, _ = fmt.Fprintf(w, "  Course:\t%s\n", cyan(target.Course.Name))
+		_, _ = fmt.Fprintf(w, "  Codex category:\t%s\n", cyan(target.CodexCategory.Name))
+	} else {
+		_, _ = fmt.Fprintf(w, "  Codex category:\t%s\n", warnf(
+			"%s (not found in any of the courses you own)",
+			target.CodexCategoryId,
+		))
+	}
+	switch {
+	case !target.IsReplace():
+		_, _ = fmt.Fprintf(w, "  Codex:\t(new codex)\n")
+	case target.CodexName == "":
+		_, _ = fmt.Fprintf(w, "  Codex:\t%s\n", warnf(
+			"REPLACES codex %s (which could not be found)",
+			target.CodexId,
+		))
+	default:
+		_, _ = fmt.Fprintf(w, "  Codex:\t%s\n", warnf(
+			"REPLACES existing codex %q (%s)",
+			target.CodexName,
+			target.CodexId,
+		))
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "Uploading codex to:")
+	_ = w.Flush()
+
+	if target.IsReplace() {
+		return prompt.Confirm("Replace the existing codex?")
+	}
+	return prompt.Confirm("Upload codex?")
+}
+
+func codexDetailsUrl(codexId string) string {
+	return fmt.Sprintf("https://pathbird.com/codex/%s/details", codexId)
+}
+
 var (
 	failf    = color.New(color.FgRed, color.Bold).SprintfFunc()
+	warnf    = color.New(color.FgYellow, color.Bold).SprintfFunc()
 	successf = color.New(color.FgGreen, color.Bold).SprintfFunc()
 	cyan     = color.New(color.FgCyan).SprintFunc()
 	faint    = color.New(color.Faint).SprintFunc()
@@ -0,0 +1,340 @@
+package codex
+
+import (
+	"context"
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pathbird/pbauthor/internal/graphql"
+	"github.com/pathbird/pbauthor/internal/prompt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// flag vars
+var (
+	uploadAllJobs             int
+	uploadAllSkipConfirmation bool
+	uploadAllNoWait           bool
+	uploadAllWaitTimeout      time.Duration
+	uploadAllEnv              string
+	uploadAllFull             bool
+	uploadAllCompression      string
+)
+
+const defaultUploadAllJobs = 4
+
+var codexUploadAllCmd = &cobra.Command{
+	Use:   "upload-all [root]",
+	Short: "Upload every codex below a directory",
+	Long: `Upload every codex below a directory (i.e., every directory that contains a
+codex.toml file), several at a time, and wait for all of the kernel builds to
+complete.`,
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return cmd.Usage()
+		}
+		root := "."
+		if len(args) == 1 {
+			root = args[0]
+		}
+		root, err := filepath.Abs(root)
+		if err != nil {
+			return errors.Wrap(err, "invalid directory")
+		}
+		if uploadAllJobs < 1 {
+			return errors.New("--jobs must be at least 1")
+		}
+
+		dirs, err := codex.FindCodexDirs(root)
+		if err != nil {
+			return err
+		}
+		if len(dirs) == 0 {
+			return errors.Errorf("no codex directories (containing %s) found in %s", codex.ConfigFileName, root)
+		}
+
+		auth, err := auth.GetAuth()
+		if err != nil {
+			return err
+		}
+		if auth == nil {
+			return errors.New("not authenticated")
+		}
+		client := api.New(auth.ApiToken)
+		graphqlClient := graphql.NewClient(auth)
+
+		// Load the user config up front (the uploads read it concurrently)
+		userConfig, err := config.GetUserConfig()
+		if err != nil {
+			return err
+		}
+		if !uploadAllNoWait && !cmd.Flags().Changed("wait-timeout") {
+			uploadAllWaitTimeout = userConfig.GetUploadWaitTimeout()
+		}
+
+		if !uploadAllSkipConfirmation && !confirmUploadAll(graphqlClient, root, dirs) {
+			_, _ = fmt.Fprintln(os.Stderr, failf("Upload aborted."))
+			os.Exit(1)
+		}
+
+		results := uploadAll(client, graphqlClient, root, dirs)
+		if failed := printUploadAllSummary(results); failed > 0 {
+			return errors.Errorf("%d of %d codices failed", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	codexUploadAllCmd.Flags().IntVarP(
+		&uploadAllJobs,
+		"jobs",
+		"j",
+		defaultUploadAllJobs,
+		"the number of codices to upload at the same time",
+	)
+	codexUploadAllCmd.Flags().BoolVarP(
+		&uploadAllSkipConfirmation,
+		"yes",
+		"y",
+		false,
+		"don't ask for confirmation",
+	)
+	codexUploadAllCmd.Flags().BoolVar(
+		&uploadAllNoWait,
+		"no-wait",
+		false,
+		"don't wait for the kernel build processes to complete",
+	)
+	codexUploadAllCmd.Flags().DurationVar(
+		&uploadAllWaitTimeout,
+		"wait-timeout",
+		0,
+		"how long to wait for each kernel build process to complete (default: the upload_wait_timeout setting)",
+	)
+	codexUploadAllCmd.Flags().StringVar(
+		&uploadAllEnv,
+		"env",
+		"",
+		"the environment to upload to (as defined by an [env.<name>] table in each codex.toml)",
+	)
+	codexUploadAllCmd.Flags().BoolVar(
+		&uploadAllFull,
+		"full",
+		false,
+		"upload all files (even the ones that haven't changed since the last upload)",
+	)
+	codexUploadAllCmd.Flags().StringVar(
+		&uploadAllCompression,
+		"compression",
+		"",
+		"how to compress the uploaded files (default: the upload.compression setting of each codex)",
+	)
+	Cmd.AddCommand(codexUploadAllCmd)
+}
+
+// Show where every codex is going to be uploaded and ask for confirmation once
+// (rather than once per codex).
+func confirmUploadAll(graphqlClient *graphql.Client, root string, dirs []string) bool {
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "CODEX\tCOURSE\tCODEX CATEGORY\tUPLOAD")
+	replaces := 0
+	for _, dir := range dirs {
+		name := relativeCodexDir(root, dir)
+		config, err := codex.LoadConfig(dir, uploadAllEnv)
+		if err != nil {
+			// This will be reported when the codex is uploaded
+			log.WithError(err).Debugf("failed to load codex config for %s", name)
+			_, _ = fmt.Fprintf(w, "%s\t\t\t%s\n", name, failf("invalid config"))
+			continue
+		}
+		target, err := codex.ResolveUploadTarget(context.Background(), graphqlClient, config)
+		if err != nil {
+			log.WithError(err).Debugf("failed to resolve upload target for %s", name)
+			_, _ = fmt.Fprintf(w, "%s\t?\t%s\t?\n", name, config.Upload.CodexCategory)
+			continue
+		}
+		course, category := "?", warnf("%s (not found)", target.CodexCategoryId)
+		if target.CodexCategory != nil {
+			course, category = cyan(target.Course.Name), cyan(target.CodexCategory.Name)
+		}
+		upload := "new codex"
+		if target.IsReplace() {
+			replaces++
+			upload = warnf("REPLACES %s", target.CodexId)
+			if target.CodexName != "" {
+				upload = warnf("REPLACES %q", target.CodexName)
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, course, category, upload)
+	}
+	_ = w.Flush()
+
+	if replaces > 0 {
+		return prompt.Confirm(fmt.Sprintf("Upload %d codices (replacing %d existing codices)?", len(dirs), replaces))
+	}
+	return prompt.Confirm(fmt.Sprintf("Upload %d codices?", len(dirs)))
+}
+
+type uploadAllStatus string
+
+const (
+	statusUploaded     uploadAllStatus = "uploaded"
+	statusBuilt        uploadAllStatus = "built"
+	statusUploadFailed uploadAllStatus = "upload failed"
+	statusParseFailed  uploadAllStatus = "parse failed"
+	statusBuildFailed  uploadAllStatus = "build failed"
+)
+
+type uploadAllResult struct {
+	// The codex directory (relative to the root)
+	Name    string
+	Status  uploadAllStatus
+	CodexId string
+	Details string
+}
+
+func (r *uploadAllResult) failed() bool {
+	return r.Status != statusUploaded && r.Status != statusBuilt
+}
+
+// Upload the codices with a pool of uploadAllJobs workers. The kernel builds
+// are waited for in parallel (without occupying a worker).
+func uploadAll(
+	client *api.Client,
+	graphqlClient *graphql.Client,
+	root string,
+	dirs []string,
+) []*uploadAllResult {
+	results := make([]*uploadAllResult, len(dirs))
+	jobs := make(chan int)
+	var uploads, builds sync.WaitGroup
+
+	for worker := 0; worker < uploadAllJobs; worker++ {
+		uploads.Add(1)
+		go func() {
+			defer uploads.Done()
+			for i := range jobs {
+				result := uploadOne(client, relativeCodexDir(root, dirs[i]), dirs[i])
+				results[i] = result
+				if result.Status != statusUploaded || uploadAllNoWait {
+					continue
+				}
+				builds.Add(1)
+				go func() {
+					defer builds.Done()
+					waitForBuild(graphqlClient, result)
+				}()
+			}
+		}()
+	}
+
+	for i := range dirs {
+		jobs <- i
+	}
+	close(jobs)
+	uploads.Wait()
+	builds.Wait()
+	return results
+}
+
+func uploadOne(client *api.Client, name string, dir string) *uploadAllResult {
+	result := &uploadAllResult{Name: name}
+	log.Infof("%s: uploading codex", name)
+	res, parseErr, err := codex.UploadCodex(client, &codex.UploadCodexOptions{
+		Dir:         dir,
+		Env:         uploadAllEnv,
+		Full:        uploadAllFull,
+		Compression: uploadAllCompression,
+	})
+	switch {
+	case err != nil:
+		result.Status = statusUploadFailed
+		result.Details = err.Error()
+	case parseErr != nil:
+		result.Status = statusParseFailed
+		result.Details = fmt.Sprintf("%d issues", len(parseErr.Errors))
+		if len(parseErr.Errors) > 0 {
+			first := parseErr.Errors[0]
+			result.Details += ": " + first.Message
+			if first.SourcePosition != "" {
+				result.Details += " (at " + first.SourcePosition + ")"
+			}
+		}
+	default:
+		result.Status = statusUploaded
+		result.CodexId = res.CodexId
+		result.Details = codexDetailsUrl(res.CodexId)
+	}
+	log.Infof("%s: %s", name, result.Status)
+	return result
+}
+
+func waitForBuild(graphqlClient *graphql.Client, result *uploadAllResult) {
+	ctx, cancel := context.WithTimeout(context.Background(), uploadAllWaitTimeout)
+	defer cancel()
+	start := time.Now()
+	// The build logs of several codices would be interleaved, so they're
+	// discarded (they can be viewed on the codex details page)
+	kernelStatus, err := codex.WaitForKernelBuildCompletedWithLog(
+		ctx,
+		graphqlClient,
+		result.CodexId,
+		ioutil.Discard,
+	)
+	switch {
+	case err != nil:
+		result.Status = statusBuildFailed
+		result.Details = fmt.Sprintf("failed to check the kernel build status: %s (%s)", err, result.Details)
+	case kernelStatus.BuildStatus != "built":
+		result.Status = statusBuildFailed
+		result.Details = fmt.Sprintf("got status: %s (%s)", kernelStatus.BuildStatus, result.Details)
+	default:
+		result.Status = statusBuilt
+	}
+	log.Infof("%s: %s (after %s)", result.Name, result.Status, time.Since(start).Round(time.Second))
+}
+
+// Print a table of the results and return the number of codices that failed.
+func printUploadAllSummary(results []*uploadAllResult) int {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "CODEX\tSTATUS\tDETAILS")
+	for _, r := range results {
+		status := successf("%s", r.Status)
+		if r.failed() {
+			failed++
+			status = failf("%s", r.Status)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, status, r.Details)
+	}
+	_ = w.Flush()
+
+	fmt.Println()
+	if failed == 0 {
+		fmt.Println(successf("Successfully uploaded %d codices.", len(results)))
+	} else {
+		fmt.Println(failf("%d of %d codices failed.", failed, len(results)))
+	}
+	return failed
+}
+
+func relativeCodexDir(root string, dir string) string {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return dir
+	}
+	return filepath.ToSlash(rel)
+}
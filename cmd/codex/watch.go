@@ -0,0 +1,94 @@
+package codex
+
+import (
+	"context"
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/codex"
+	"github.com/pathbird/pbauthor/internal/graphql"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWatchDebounce = 1 * time.Second
+	watchPollInterval    = 500 * time.Millisecond
+	// The number of changed files to list before each upload
+	watchChangesShown = 5
+)
+
+// Upload the codex, then upload it again whenever it changes (until the
+// process is interrupted). Problems with the codex (e.g., parse errors) are
+// reported after each upload without exiting.
+func watchAndUpload(
+	client *api.Client,
+	graphqlClient *graphql.Client,
+	opts *codex.UploadCodexOptions,
+) error {
+	// Ask for confirmation before the first upload, and again whenever the
+	// upload target changes (e.g., if upload.codex_category or upload.codex_id
+	// is changed while watching). Later uploads to the same target replace the
+	// codex that was just uploaded, so they aren't confirmed again.
+	var confirmed *codex.UploadTarget
+	declined := false
+	if confirm := opts.Confirm; confirm != nil {
+		opts.Confirm = func(target *codex.UploadTarget) bool {
+			if confirmed != nil &&
+				target.CodexCategoryId == confirmed.CodexCategoryId &&
+				target.CodexId == confirmed.CodexId {
+				return true
+			}
+			declined = !confirm(target)
+			if !declined {
+				confirmed = target
+			}
+			return !declined
+		}
+	}
+	upload := func() {
+		reportUploadError(uploadAndReport(client, graphqlClient, opts))
+		if confirmed != nil && !confirmed.IsReplace() {
+			// If a new codex was created, the later uploads replace it (and
+			// its ID was saved to the codex config)
+			if config, err := codex.LoadConfig(opts.Dir, opts.Env); err == nil &&
+				config.Upload.CodexCategory == confirmed.CodexCategoryId {
+				confirmed.CodexId = config.Upload.CodexId
+			}
+		}
+	}
+
+	upload()
+	if declined {
+		os.Exit(1)
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "\nWatching %s for changes (press Ctrl+C to stop)...\n", cyan(opts.Dir))
+	return codex.Watch(context.Background(), &codex.WatchOptions{
+		Dir:          opts.Dir,
+		Env:          opts.Env,
+		PollInterval: watchPollInterval,
+		Debounce:     watchDebounce,
+	}, func(changed []string) {
+		shown := changed
+		if len(shown) > watchChangesShown {
+			shown = shown[:watchChangesShown]
+		}
+		description := strings.Join(shown, ", ")
+		if len(changed) > len(shown) {
+			description += fmt.Sprintf(" (and %d more)", len(changed)-len(shown))
+		}
+		_, _ = fmt.Fprintf(os.Stderr, "\n%s %s\n", faint(time.Now().Format("15:04:05")), description)
+
+		upload()
+		_, _ = fmt.Fprintln(os.Stderr, faint("Watching for changes..."))
+	})
+}
+
+// Print an error from uploadAndReport (problems with the codex itself have
+// already been reported).
+func reportUploadError(err error) {
+	if err != nil && err != errUploadRejected {
+		_, _ = fmt.Fprintf(os.Stderr, "%s %s\n", failf("Upload failed:"), err)
+	}
+}
@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage global settings",
+	Long: "Manage global settings.\n\n" +
+		"Settings are stored in ~/.pathbird/config.toml. Each setting can be\n" +
+		"overridden with an environment variable (e.g., PATHBIRD_API_HOST for\n" +
+		"api_host), and command line flags take precedence over both.\n\n" +
+		"Available settings:\n" +
+		"  api_host                the default Pathbird API host\n" +
+		"  credentials_store       where to store credentials (file, encrypted-file, or keyring)\n" +
+		"  color                   whether to use colors in output (true or false)\n" +
+		"  check_for_updates       whether to check for new versions of pbauthor (true or false)\n" +
+		"  upload_wait_timeout     how long to wait for kernel builds after uploading (e.g., 20m)\n" +
+		"  default_course          the ID of the course to use in `pbauthor codex init`\n" +
+		"  default_codex_category  the ID of the codex category to use in `pbauthor codex init`",
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a setting",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return cmd.Usage()
+		}
+		c, err := config.GetUserConfig()
+		if err != nil {
+			return err
+		}
+		value, _, err := c.Get(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Change the value of a setting",
+	Long:  "Change the value of a setting (set a setting to \"\" to unset it).",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return cmd.Usage()
+		}
+		c, err := config.LoadUserConfigFile()
+		if err != nil {
+			return err
+		}
+		if err := c.Set(args[0], args[1]); err != nil {
+			return err
+		}
+		return config.SaveUserConfigFile(c)
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all settings",
+
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := config.GetUserConfig()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, key := range config.UserConfigKeys() {
+			value, set, err := c.Get(key.Name)
+			if err != nil {
+				return err
+			}
+			source := "config file"
+			if !set {
+				value = key.Default
+				source = "default"
+			} else if envValue, envSet := os.LookupEnv(key.Env); envSet && envValue != "" {
+				source = key.Env
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", key.Name, value, source)
+		}
+		return w.Flush()
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configListCmd)
+}
@@ -2,12 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/fatih/color"
 	"github.com/pathbird/pbauthor/cmd/auth"
 	"github.com/pathbird/pbauthor/cmd/codex"
 	"github.com/pathbird/pbauthor/internal/config"
 	"github.com/pathbird/pbauthor/internal/version"
 	"github.com/spf13/cobra"
 	"os"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -15,6 +17,7 @@ import (
 // flag vars
 var (
 	verbose bool
+	noColor bool
 )
 
 var rootCmd = &cobra.Command{
@@ -27,7 +30,28 @@ var rootCmd = &cobra.Command{
 		if err := setUpLog(verbose); err != nil {
 			panic(err)
 		}
-		version.CheckVersionAndPrintUpgradeNotice()
+		userConfig, err := config.GetUserConfig()
+		if err != nil {
+			// Don't prevent the user from fixing a broken config file
+			if !isSubcommandOf(cmd, configCmd) {
+				return err
+			}
+			log.Warn(err)
+			userConfig = &config.UserConfig{}
+		}
+		setUpColor(cmd, userConfig)
+
+		if cmd.Flags().Changed("api-host") {
+			config.PathbirdApiHost = strings.TrimRight(config.PathbirdApiHost, "/")
+			config.ApiHostExplicit = true
+		}
+		config.ResolveApiHost()
+		// The credentials (and the active profile) are only loaded by the
+		// commands that need them (since loading them may require, e.g., a
+		// passphrase)
+		if userConfig.GetCheckForUpdates() {
+			version.CheckVersionAndPrintUpgradeNotice()
+		}
 		return nil
 	},
 }
@@ -50,18 +74,53 @@ func init() {
 		false,
 		"enable verbose logging (for debugging)",
 	)
+	rootCmd.PersistentFlags().BoolVar(
+		&noColor,
+		"no-color",
+		false,
+		"disable colors in output",
+	)
 	rootCmd.PersistentFlags().StringVar(
 		&config.PathbirdApiHost,
 		"api-host",
 		config.PathbirdApiHost,
 		"Pathbird API host",
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&config.Profile,
+		"profile",
+		config.Profile,
+		"the credentials profile to use (see `pbauthor auth profiles`)",
+	)
 
 	rootCmd.AddCommand(versionCmd)
+	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(auth.Cmd)
 	rootCmd.AddCommand(codex.Cmd)
 }
 
+func isSubcommandOf(cmd *cobra.Command, parent *cobra.Command) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == parent {
+			return true
+		}
+	}
+	return false
+}
+
+// Colors are enabled automatically when writing to a terminal (and disabled if
+// the NO_COLOR environment variable is set). The --no-color flag and the color
+// setting override that.
+func setUpColor(cmd *cobra.Command, userConfig *config.UserConfig) {
+	if cmd.Flags().Changed("no-color") {
+		color.NoColor = noColor
+	} else if _, set := os.LookupEnv("NO_COLOR"); set {
+		color.NoColor = true
+	} else if userConfig.Color != nil {
+		color.NoColor = !*userConfig.Color
+	}
+}
+
 func setUpLog(verbose bool) error {
 	if verbose {
 		log.SetLevel(log.DebugLevel)
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+)
+
+// RevokeToken revokes the client's auth token so that it can't be used again
+// (e.g., when logging out).
+func (c *Client) RevokeToken() error {
+	if c.Auth() == "" {
+		return errors.New("cannot revoke token: client has no auth token")
+	}
+
+	const route = "auth/logout"
+	req, err := c.newRequest("POST", route, "application/json", nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to construct logout request")
+	}
+	httpRes, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	res := &response{route, httpRes}
+	defer res.Close()
+
+	statusErr, err := res.StatusError()
+	if err != nil {
+		return err
+	}
+	if statusErr != nil {
+		return statusErr
+	}
+	return nil
+}
@@ -12,27 +12,70 @@ import (
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"sync"
 )
 
 type Client struct {
+	// authToken is guarded by mu (since it's replaced when reauthenticating,
+	// and clients are shared by concurrent uploads)
+	mu         sync.Mutex
 	authToken  string
 	host       string
 	httpClient *http.Client
 }
 
 func New(authToken string) *Client {
-	log.Debugf("creating API client using host: %s", config.PathbirdApiHost)
+	return NewForHost(config.PathbirdApiHost, authToken)
+}
+
+// NewForHost creates a client for a specific API host (rather than the host
+// that was selected for the current invocation).
+func NewForHost(host string, authToken string) *Client {
+	log.Debugf("creating API client using host: %s", host)
 	return &Client{
 		authToken:  authToken,
-		host:       fmt.Sprintf("%s/api", config.PathbirdApiHost),
+		host:       fmt.Sprintf("%s/api", host),
 		httpClient: http.DefaultClient,
 	}
 }
 
 func (c *Client) Auth() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	return c.authToken
 }
 
+// ErrUnauthenticated is returned when the API rejects the client's credentials.
+var ErrUnauthenticated = errors.New("You are not logged in (try running `pbauthor auth login`)")
+
+// Reauthenticate is called when the API rejects the client's credentials.
+// It's given the token that was rejected and should return a new auth token
+// (or an error if the user can't log in again, e.g., because we're not running
+// interactively). It's safe to call concurrently.
+// This is set by the auth package (which depends on this package).
+var Reauthenticate func(rejected string) (string, error)
+
+// Run an operation, reauthenticating and retrying it once if the API rejects
+// the client's credentials.
+func (c *Client) retryUnauthenticated(op func() error) error {
+	rejected := c.Auth()
+	err := op()
+	if errors.Cause(err) != ErrUnauthenticated || Reauthenticate == nil {
+		return err
+	}
+
+	log.Debug("api request was unauthenticated, attempting to reauthenticate")
+	token, reauthErr := Reauthenticate(rejected)
+	if reauthErr != nil {
+		log.WithError(reauthErr).Debug("failed to reauthenticate")
+		return err
+	}
+	c.mu.Lock()
+	c.authToken = token
+	c.mu.Unlock()
+	return op()
+}
+
 type request struct {
 	route string
 	body  interface{}
@@ -267,8 +310,8 @@ func (c *Client) postMultipart(r *multipartRequest) (*response, error) {
 
 func (c *Client) do(req *http.Request) (*http.Response, error) {
 	req.Header.Set("User-Agent", userAgent)
-	if c.authToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.authToken))
+	if token := c.Auth(); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 	}
 	log.Debugf("sending api request (%s)", req.URL.Path)
 	httpResponse, err := c.httpClient.Do(req)
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"path/filepath"
+)
+
+// ManifestEntry describes the contents of a codex file.
+type ManifestEntry struct {
+	// The (slash-separated) name of the file (see FileRef.Name)
+	Name string `json:"name"`
+	// The hex-encoded SHA-256 digest of the file
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Whether or not the file was omitted from the tar archive (because the
+	// API already has a blob with the same digest)
+	Omitted bool `json:"omitted,omitempty"`
+}
+
+// CodexFile returns the codex source file (i.e., the notebook), which is
+// always sent (outside of the tar archive).
+func (r *UploadCodexRequest) CodexFile() (FileRef, error) {
+	return getCodexFile(r.Files)
+}
+
+// Get the names of the files that should be omitted from the tar archive.
+func (r *UploadCodexRequest) omittedFiles() map[string]bool {
+	omit := make(map[string]bool)
+	for _, e := range r.Manifest {
+		if e.Omitted {
+			omit[filepath.ToSlash(e.Name)] = true
+		}
+	}
+	return omit
+}
+
+type CheckCodexBlobsRequest struct {
+	// The codex whose files were previously uploaded
+	CodexId string `json:"codexId"`
+	// The SHA-256 digests of the blobs
+	Blobs []string `json:"blobs"`
+}
+
+type CheckCodexBlobsResponse struct {
+	// The digests of the blobs that the API already has (and which therefore
+	// don't need to be uploaded again)
+	Existing []string `json:"existing"`
+}
+
+// CheckCodexBlobs asks the API which of the blobs (i.e., file contents) from a
+// previous upload of a codex it still has.
+func (c *Client) CheckCodexBlobs(r *CheckCodexBlobsRequest) (res *CheckCodexBlobsResponse, err error) {
+	err = c.retryUnauthenticated(func() error {
+		return withRetries("checking codex blobs", func(int) (bool, error) {
+			var checkErr error
+			res, checkErr = c.checkCodexBlobs(r)
+			return false, checkErr
+		})
+	})
+	return res, err
+}
+
+func (c *Client) checkCodexBlobs(r *CheckCodexBlobsRequest) (*CheckCodexBlobsResponse, error) {
+	reqBody, err := json.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal request")
+	}
+
+	const route = "author/upload-codex/blobs"
+	req, err := c.newRequest("POST", route, "application/json", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to construct check codex blobs request")
+	}
+	httpRes, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransientStatus(route, httpRes); err != nil {
+		return nil, err
+	}
+	res := &response{route, httpRes}
+	defer res.Close()
+
+	statusErr, err := res.StatusError()
+	if err != nil {
+		return nil, err
+	}
+	if statusErr != nil {
+		if statusErr.error.Error == "ErrUnauthenticated" {
+			return nil, ErrUnauthenticated
+		}
+		return nil, statusErr
+	}
+	resp := &CheckCodexBlobsResponse{}
+	if err := res.UnmarshalJson(resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// UploadLimits are the limits that the API enforces for codex uploads.
+// Zero means that there's no limit.
+type UploadLimits struct {
+	// The maximum number of files in a codex
+	MaxFiles int `json:"maxFiles"`
+	// The maximum size of a single file
+	MaxFileSize int64 `json:"maxFileSize"`
+	// The maximum size of the (multipart) request body
+	MaxPayloadSize int64 `json:"maxPayloadSize"`
+}
+
+// ErrUploadTooLarge is returned by UploadCodex if the API rejects the upload
+// because the request body is too large.
+var ErrUploadTooLarge = errors.New(
+	"the api rejected the upload because it's too large (exclude large files with upload.exclude or a .pbignore file)",
+)
+
+// ErrUploadLimitsUnavailable is returned by GetUploadLimits if the API doesn't
+// advertise its upload limits.
+var ErrUploadLimitsUnavailable = errors.New("the api does not advertise upload limits")
+
+// GetUploadLimits gets the limits that the API enforces for codex uploads.
+// Unlike most requests, this isn't retried since callers can fall back to
+// default limits.
+func (c *Client) GetUploadLimits() (limits *UploadLimits, err error) {
+	err = c.retryUnauthenticated(func() error {
+		var getErr error
+		limits, getErr = c.getUploadLimits()
+		return getErr
+	})
+	return limits, err
+}
+
+func (c *Client) getUploadLimits() (*UploadLimits, error) {
+	const route = "author/upload-codex/limits"
+	req, err := c.newRequest("GET", route, "application/json", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to construct upload limits request")
+	}
+	httpRes, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTransientStatus(route, httpRes); err != nil {
+		return nil, err
+	}
+	res := &response{route, httpRes}
+	defer res.Close()
+	if httpRes.StatusCode == http.StatusNotFound {
+		return nil, ErrUploadLimitsUnavailable
+	}
+
+	statusErr, err := res.StatusError()
+	if err != nil {
+		return nil, err
+	}
+	if statusErr != nil {
+		if statusErr.error.Error == "ErrUnauthenticated" {
+			return nil, ErrUnauthenticated
+		}
+		return nil, statusErr
+	}
+	limits := &UploadLimits{}
+	if err := res.UnmarshalJson(limits); err != nil {
+		return nil, err
+	}
+	return limits, nil
+}
+
+// UploadCodexPayloadSize estimates the size of the request body that
+// UploadCodex would send from the sizes of the files.
+// The size of a compressed body can't be known without compressing all of the
+// files (which UploadCodex would then have to do again), so zero is returned
+// in that case (and the API rejects uploads that are too large anyway, see
+// ErrUploadTooLarge).
+func UploadCodexPayloadSize(r *UploadCodexRequest) (int64, error) {
+	if isCompressed(r.Compression) {
+		return 0, nil
+	}
+	return estimateUploadSize(r.Files, r.omittedFiles())
+}
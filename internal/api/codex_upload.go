@@ -2,6 +2,8 @@ package api
 
 import (
 	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/djherbis/buffer"
@@ -9,9 +11,12 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"io"
+	"io/ioutil"
 	"mime/multipart"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
@@ -29,10 +34,43 @@ type UploadCodexRequest struct {
 	CodexId string `json:"replaceCodexId,omitempty"`
 
 	KernelOptions KernelOptions `json:"kernelOptions"`
+
+	// optional
+	// the content hashes of the codex files (which the API uses to restore the
+	// files that were omitted from the tar archive because it already has them)
+	Manifest []ManifestEntry `json:"manifest,omitempty"`
+
+	// optional
+	// how the tar archive is compressed (see Compressions)
+	Compression string `json:"bodyCompression,omitempty"`
+
+	// optional
+	// if set, the modification times of the files in the tar archive are set to
+	// the Unix epoch (so that the archive only depends on the contents of the
+	// files)
+	ZeroModTimes bool `json:"-"`
+
+	// optional
+	// called periodically with the number of bytes of the request body that
+	// have been written so far and the estimated total size of the request body
+	Progress ProgressFunc `json:"-"`
 }
 
+type ProgressFunc func(written int64, total int64)
+
 type KernelOptions struct {
 	SystemPackages []string `json:"systemPackages,omitempty"`
+	PythonVersion  string   `json:"pythonVersion,omitempty"`
+	PipPackages    []string `json:"pipPackages,omitempty"`
+	// The paths of the requirements/environment files are relative to the
+	// root of the codex bundle (and always use forward slashes).
+	RequirementsFile string `json:"requirementsFile,omitempty"`
+	EnvironmentFile  string `json:"environmentFile,omitempty"`
+
+	EnvVars        map[string]string `json:"envVars,omitempty"`
+	Cpu            float64           `json:"cpu,omitempty"`
+	MemoryBytes    int64             `json:"memoryBytes,omitempty"`
+	TimeoutSeconds int64             `json:"timeoutSeconds,omitempty"`
 }
 
 type UploadCodexResponse struct {
@@ -61,8 +99,40 @@ func (e *CodexParseFailedError) Error() string {
 
 var _ error = (*CodexParseFailedError)(nil)
 
+// UploadCodex uploads a codex.
+// Transient failures (e.g., dropped connections) are retried with exponential
+// backoff if that's safe: re-uploading a codex that replaces an existing codex
+// (i.e., with the same replaceCodexId) has the same effect as uploading it
+// once, but re-uploading a new codex would create a duplicate if the API
+// received the first attempt (and we just didn't get the response), so new
+// codices are only retried if the request wasn't sent at all.
+//
+// Every attempt also sends the same Idempotency-Key header (so that the API
+// can detect duplicate uploads), but nothing relies on the API supporting it.
 func (c *Client) UploadCodex(
 	r *UploadCodexRequest,
+) (res *UploadCodexResponse, parseErr *CodexParseFailedError, err error) {
+	idempotencyKey, err := newIdempotencyKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	retryable := isTransient
+	if r.CodexId == "" {
+		retryable = isUnsent
+	}
+	err = c.retryUnauthenticated(func() error {
+		return withRetriesIf("codex upload", retryable, func(int) (bool, error) {
+			var uploadErr error
+			res, parseErr, uploadErr = c.uploadCodex(r, idempotencyKey)
+			return false, uploadErr
+		})
+	})
+	return res, parseErr, err
+}
+
+func (c *Client) uploadCodex(
+	r *UploadCodexRequest,
+	idempotencyKey string,
 ) (*UploadCodexResponse, *CodexParseFailedError, error) {
 	// Do this first so we can bail out early
 	codexFile, err := getCodexFile(r.Files)
@@ -72,7 +142,25 @@ func (c *Client) UploadCodex(
 
 	buf := buffer.New(1024 * 16)
 	pr, pw := nio.Pipe(buf)
-	form := multipart.NewWriter(pw)
+	body := &countingWriter{w: pw}
+	var onTarWrite func(n int64)
+	if r.Progress != nil {
+		total, err := estimateUploadSize(r.Files, r.omittedFiles())
+		if err != nil {
+			return nil, nil, err
+		}
+		reportProgress := func(written int64) {
+			r.Progress(written, total)
+		}
+		if isCompressed(r.Compression) {
+			// We can't know the compressed size in advance, so report the
+			// progress of the (uncompressed) tar archive instead
+			onTarWrite = reportProgress
+		} else {
+			body.onWrite = reportProgress
+		}
+	}
+	form := multipart.NewWriter(body)
 
 	// Write the request asynchronously
 	writeRequest := func() error {
@@ -81,45 +169,18 @@ func (c *Client) UploadCodex(
 				log.WithError(err).Debug("failed to finalize form for codex upload")
 			}
 		}()
-
-		requestFormFile, err := form.CreateFormFile("request", "request.json")
-		if err != nil {
-			return errors.Wrap(err, "initializing upload codex request")
-		}
-		if err := json.NewEncoder(requestFormFile).Encode(r); err != nil {
-			return errors.Wrap(err, "initializing upload codex request")
-		}
-
-		codexFormFile, err := form.CreateFormFile("codex", codexFile.Name)
-		if err != nil {
-			err = errors.Wrap(err, "failed to initialize upload codex request")
-			return err
-		}
-		if err := codexFile.copyTo(codexFormFile); err != nil {
-			err = errors.Wrap(err, "failed to initialize upload codex request")
-			return err
-		}
-
-		tarFormFile, err := form.CreateFormFile("body", "body.tar")
-		if err != nil {
-			err = errors.Wrap(err, "failed to initialize upload codex request")
-			return err
-		}
-
-		if err := writeCodexTar(tarFormFile, r.Files, codexFile); err != nil {
-			err = errors.Wrap(err, "failed to upload codex files")
-			return err
-		}
-
-		log.Debugf("wrote all request files for codex upload")
-		return nil
+		return writeUploadCodexForm(form, r, codexFile, onTarWrite)
 	}
 	go func() {
 		if err := writeRequest(); err != nil {
 			// This only a debug since we report the "primary" error that is returned by
 			// c.do below.
 			log.WithError(err).Debug("failed to write request for codex upload")
+			_ = pw.CloseWithError(err)
+			return
 		}
+		// Signal the end of the request body
+		_ = pw.Close()
 	}()
 
 	// Actually make the HTTP request
@@ -130,24 +191,187 @@ func (c *Client) UploadCodex(
 
 	httpReq, err := c.newRequest("POST", "author/upload-codex", contentType, pr)
 	if err != nil {
+		_ = pr.CloseWithError(err)
 		return nil, nil, errors.Wrap(err, "uploading codex (creating HTTP request)")
 	}
+	httpReq.Header.Set("Idempotency-Key", idempotencyKey)
 	httpRes, err := c.do(httpReq)
 	if err != nil {
+		// Stop writing the request body (if we haven't already)
+		_ = pr.CloseWithError(err)
 		return nil, nil, errors.Wrap(err, "uploading codex (HTTP request)")
 	}
+	if err := checkTransientStatus(httpReq.URL.Path, httpRes); err != nil {
+		_ = pr.CloseWithError(err)
+		return nil, nil, err
+	}
 
-	// Close the writer pipe.
+	// Close the pipe (so that the writer stops).
 	// We do this because sometimes the Saturn API returns a response before we've written the entire
 	// request body (e.g., if it's returning an HTTP 403, it doesn't need to read the entire tarrball
 	// to know that). If that happens we want to stop sending data.
-	_ = pw.Close()
+	_ = pr.Close()
 
 	res := &response{httpReq.URL.Path, httpRes}
 	time.Sleep(2 * time.Second)
 	return parseCodexUploadResponse(res)
 }
 
+// If set, onTarWrite is called with the number of bytes of the (uncompressed)
+// tar archive that have been written so far.
+func writeUploadCodexForm(
+	form *multipart.Writer,
+	r *UploadCodexRequest,
+	codexFile FileRef,
+	onTarWrite func(n int64),
+) error {
+	requestFormFile, err := form.CreateFormFile("request", "request.json")
+	if err != nil {
+		return errors.Wrap(err, "initializing upload codex request")
+	}
+	if err := json.NewEncoder(requestFormFile).Encode(r); err != nil {
+		return errors.Wrap(err, "initializing upload codex request")
+	}
+
+	codexFormFile, err := form.CreateFormFile("codex", codexFile.Name)
+	if err != nil {
+		err = errors.Wrap(err, "failed to initialize upload codex request")
+		return err
+	}
+	if err := codexFile.copyTo(codexFormFile); err != nil {
+		err = errors.Wrap(err, "failed to initialize upload codex request")
+		return err
+	}
+
+	tarFormFile, err := createTarFormPart(form, r.Compression)
+	if err != nil {
+		err = errors.Wrap(err, "failed to initialize upload codex request")
+		return err
+	}
+
+	tarWriter := &countingWriter{w: tarFormFile, onWrite: onTarWrite}
+	if err := writeCodexTar(tarWriter, r, codexFile); err != nil {
+		err = errors.Wrap(err, "failed to upload codex files")
+		return err
+	}
+	if err := tarFormFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to compress codex tar archive")
+	}
+
+	log.Debugf("wrote all request files for codex upload")
+	return nil
+}
+
+// UploadCodexPlan describes the request that UploadCodex would send for an
+// UploadCodexRequest (see PlanUploadCodex).
+type UploadCodexPlan struct {
+	// The codex source file (i.e., the notebook)
+	CodexFile PlannedFile
+	// The other files (which are sent as a tar archive)
+	TarFiles []PlannedFile
+	// The size of the tar archive
+	TarSize int64
+	// The SHA-256 digest of the (uncompressed) tar archive (which only depends
+	// on the files, see writeCodexTar)
+	TarSha256 string
+	// The total size of the request body
+	TotalSize int64
+}
+
+type PlannedFile struct {
+	Name string
+	Size int64
+}
+
+// PlanUploadCodex determines what would be sent by UploadCodex without actually
+// contacting the API. The request body is generated (and discarded) to
+// determine its exact size.
+func PlanUploadCodex(r *UploadCodexRequest) (*UploadCodexPlan, error) {
+	codexFile, err := getCodexFile(r.Files)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &UploadCodexPlan{}
+	omitted := r.omittedFiles()
+	for _, f := range r.Files {
+		stat, err := os.Stat(f.FsPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat codex file: %s", f.Name)
+		}
+		planned := PlannedFile{Name: f.Name, Size: stat.Size()}
+		if f.FsPath == codexFile.FsPath {
+			plan.CodexFile = planned
+		} else if !omitted[filepath.ToSlash(f.Name)] {
+			plan.TarFiles = append(plan.TarFiles, planned)
+		}
+	}
+
+	tarHash := sha256.New()
+	tarSize := &countingWriter{w: tarHash}
+	if err := writeCodexTar(tarSize, r, codexFile); err != nil {
+		return nil, errors.Wrap(err, "failed to create codex tar archive")
+	}
+	plan.TarSize = tarSize.n
+	plan.TarSha256 = hex.EncodeToString(tarHash.Sum(nil))
+
+	totalSize := &countingWriter{w: ioutil.Discard}
+	form := multipart.NewWriter(totalSize)
+	if err := writeUploadCodexForm(form, r, codexFile, nil); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to finalize upload codex request")
+	}
+	plan.TotalSize = totalSize.n
+	return plan, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+	// called (if set) after every write with the total number of bytes written
+	onWrite func(n int64)
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	if c.onWrite != nil {
+		c.onWrite(c.n)
+	}
+	return n, err
+}
+
+// Estimate the size of the request body from the sizes of the files (without
+// reading them). The estimate accounts for the tar headers and padding, but
+// not for the (small) request metadata.
+func estimateUploadSize(files []FileRef, omit map[string]bool) (int64, error) {
+	const (
+		tarBlockSize = 512
+		// Each file has a PAX header (which takes two blocks) and a regular
+		// header
+		tarHeaderSize = 3 * tarBlockSize
+	)
+	// The tar archive ends with two empty blocks
+	total := int64(2 * tarBlockSize)
+	for _, f := range files {
+		if omit[filepath.ToSlash(f.Name)] {
+			continue
+		}
+		stat, err := os.Stat(f.FsPath)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to stat codex file: %s", f.Name)
+		}
+		size := stat.Size()
+		if padding := size % tarBlockSize; padding != 0 {
+			size += tarBlockSize - padding
+		}
+		total += tarHeaderSize + size
+	}
+	return total, nil
+}
+
 func getCodexFile(fs []FileRef) (FileRef, error) {
 	// TODO:
 	// 		We should probably only search the root directory here, but oh well.
@@ -168,6 +392,10 @@ func getCodexFile(fs []FileRef) (FileRef, error) {
 }
 
 func parseCodexUploadResponse(res *response) (*UploadCodexResponse, *CodexParseFailedError, error) {
+	// This is usually returned by a proxy (so the body isn't an API error)
+	if res.httpResponse.StatusCode == http.StatusRequestEntityTooLarge {
+		return nil, nil, ErrUploadTooLarge
+	}
 	statusError, err := res.StatusError()
 	if err != nil {
 		return nil, nil, err
@@ -183,7 +411,7 @@ func parseCodexUploadResponse(res *response) (*UploadCodexResponse, *CodexParseF
 
 		case "ErrUnauthenticated":
 			log.WithError(statusError).Debug("got ErrUnauthenticated")
-			return nil, nil, errors.New("You are not logged in (try running `pbauthor auth login`)")
+			return nil, nil, ErrUnauthenticated
 
 		case "ErrClientUnsupported":
 			log.WithError(statusError).Debug("got ErrClientUnsupported")
@@ -208,7 +436,13 @@ func parseCodexUploadResponse(res *response) (*UploadCodexResponse, *CodexParseF
 	return resp, nil, nil
 }
 
-func writeCodexTar(w io.Writer, files []FileRef, exclude FileRef) (retErr error) {
+// Write the tar archive of the codex files (except for the codex file itself
+// and the files that are omitted, see ManifestEntry.Omitted).
+// The archive is normalized so that the same files always result in the same
+// archive: the entries are sorted by name, the names are slash-separated paths
+// relative to the codex directory, and the owner and permissions are fixed
+// (only the executable bit of the mode is kept).
+func writeCodexTar(w io.Writer, r *UploadCodexRequest, exclude FileRef) (retErr error) {
 	// The format for this is slightly convoluted.
 	// We upload two things (in this order) as form/multipart files:
 	// 1. A "request" JSON blob which contains the metadata for the upload (e.g., codex category, etc).
@@ -225,6 +459,13 @@ func writeCodexTar(w io.Writer, files []FileRef, exclude FileRef) (retErr error)
 		}
 	}()
 
+	files := make([]FileRef, len(r.Files))
+	copy(files, r.Files)
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].Name) < filepath.ToSlash(files[j].Name)
+	})
+	omit := r.omittedFiles()
+
 	// subtract one since we exclude the codex file here
 	nFiles := len(files) - 1
 
@@ -234,35 +475,58 @@ func writeCodexTar(w io.Writer, files []FileRef, exclude FileRef) (retErr error)
 		if f.FsPath == exclude.FsPath {
 			continue
 		}
+		name := filepath.ToSlash(f.Name)
+		if omit[name] {
+			log.Debugf("omitting unchanged file %q (%d of %d)", name, i+1, nFiles)
+			continue
+		}
 
-		log.Debugf("uploading file %q (%d of %d)", f.Name, i+1, nFiles)
+		log.Debugf("uploading file %q (%d of %d)", name, i+1, nFiles)
 		stat, err := os.Stat(f.FsPath)
 		if err != nil {
 			return errors.Wrap(err, "adding files to codex tar archive")
 		}
-
-		// Generate a tar header for! the file
-		hdr, err := tar.FileInfoHeader(stat, "")
-		if err != nil {
-			return errors.Wrap(err, "adding files to codex upload")
+		if !stat.Mode().IsRegular() {
+			return errors.Errorf("adding files to codex tar archive: not a regular file: %s", name)
 		}
-		// Use FormatPAX here for more accurate mtimes (otherwise they're truncated to the
-		// nearest second with the default USTAR format which can sometimes cause weird
-		// issues).
-		hdr.Format = tar.FormatPAX
 
-		if err := tarw.WriteHeader(hdr); err != nil {
+		if err := tarw.WriteHeader(codexTarHeader(name, stat, r.ZeroModTimes)); err != nil {
 			return errors.Wrap(err, "adding file to codex tar archive")
 		}
 		n, err := copyFile(f.FsPath, tarw)
 		if err != nil {
 			return errors.Wrap(err, "adding file to codex tar archive")
 		}
-		log.Debugf("wrote %d bytes for file %q", n, f.Name)
+		log.Debugf("wrote %d bytes for file %q", n, name)
 	}
 	return nil
 }
 
+// Generate a (normalized) tar header for a file.
+// Unlike tar.FileInfoHeader, this doesn't include anything that depends on the
+// local machine (e.g., the owner or the access time).
+func codexTarHeader(name string, stat os.FileInfo, zeroModTime bool) *tar.Header {
+	var mode int64 = 0644
+	if stat.Mode()&0111 != 0 {
+		mode = 0755
+	}
+	modTime := stat.ModTime()
+	if zeroModTime {
+		modTime = time.Unix(0, 0)
+	}
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     stat.Size(),
+		Mode:     mode,
+		ModTime:  modTime,
+		// Use FormatPAX here for more accurate mtimes (otherwise they're truncated to the
+		// nearest second with the default USTAR format which can sometimes cause weird
+		// issues).
+		Format: tar.FormatPAX,
+	}
+}
+
 func copyFile(file string, w io.Writer) (int64, error) {
 	fd, err := os.Open(file)
 	if err != nil {
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+)
+
+// The size of each chunk of a resumable upload.
+// Smaller chunks mean that less data has to be re-sent after a dropped
+// connection, at the cost of more requests.
+var uploadChunkSize int64 = 8 * 1024 * 1024
+
+// UploadSession is a resumable upload session that was started by the API.
+type UploadSession struct {
+	ID string `json:"id"`
+}
+
+// UploadSessionStore persists resumable upload sessions (so that an upload
+// that was interrupted can be resumed by a later invocation of pbauthor).
+// Sessions are keyed by the SHA-256 digest of the request body, so a session
+// is only resumed if exactly the same request is being uploaded.
+type UploadSessionStore interface {
+	// Get returns the session for the key (or nil if there isn't one).
+	Get(key string) (*UploadSession, error)
+	Put(key string, session *UploadSession) error
+	Delete(key string) error
+}
+
+var errUploadSessionNotFound = errors.New("upload session not found")
+
+// UploadCodexResumable uploads a codex in chunks. Unlike UploadCodex, a dropped
+// connection only requires the current chunk to be re-sent, and if the upload
+// fails altogether, it's resumed from the last acknowledged chunk the next time
+// the same codex is uploaded (using the session saved in the store).
+func (c *Client) UploadCodexResumable(
+	r *UploadCodexRequest,
+	sessions UploadSessionStore,
+) (res *UploadCodexResponse, parseErr *CodexParseFailedError, err error) {
+	codexFile, err := getCodexFile(r.Files)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The request body is written to a temporary file first so that we can
+	// seek to the offset of any chunk (and so that we know its size and digest
+	// before starting the upload).
+	spool, err := ioutil.TempFile("", "pbauthor-upload-*")
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create temporary file for codex upload")
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+	body, err := spoolUploadCodexForm(spool, r, codexFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debugf("spooled codex upload (size: %d, sha256: %s)", body.size, body.sha256)
+
+	err = c.retryUnauthenticated(func() error {
+		var uploadErr error
+		res, parseErr, uploadErr = c.uploadCodexResumable(r, spool, body, sessions)
+		return uploadErr
+	})
+	return res, parseErr, err
+}
+
+type spooledBody struct {
+	contentType string
+	size        int64
+	sha256      string
+}
+
+// Write the (multipart) request body to the file.
+// The multipart boundary is derived from the request and the file metadata
+// (rather than being random) so that the same request always results in the
+// same body (and the same digest).
+func spoolUploadCodexForm(
+	f *os.File,
+	r *UploadCodexRequest,
+	codexFile FileRef,
+) (*spooledBody, error) {
+	boundaryHash := sha256.New()
+	if err := json.NewEncoder(boundaryHash).Encode(r); err != nil {
+		return nil, errors.Wrap(err, "initializing upload codex request")
+	}
+	for _, file := range r.Files {
+		stat, err := os.Stat(file.FsPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat codex file: %s", file.Name)
+		}
+		modTime := stat.ModTime().UnixNano()
+		if r.ZeroModTimes {
+			modTime = 0
+		}
+		_, _ = fmt.Fprintf(boundaryHash, "%s\x00%d\x00%d\n", file.Name, stat.Size(), modTime)
+	}
+
+	bodyHash := sha256.New()
+	body := &countingWriter{w: io.MultiWriter(f, bodyHash)}
+	form := multipart.NewWriter(body)
+	if err := form.SetBoundary(hex.EncodeToString(boundaryHash.Sum(nil))[:60]); err != nil {
+		return nil, errors.Wrap(err, "initializing upload codex request")
+	}
+	if err := writeUploadCodexForm(form, r, codexFile, nil); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to finalize upload codex request")
+	}
+
+	return &spooledBody{
+		contentType: fmt.Sprintf(
+			"application/x-pb-multipart-request; boundary=%s",
+			form.Boundary(),
+		),
+		size:   body.n,
+		sha256: hex.EncodeToString(bodyHash.Sum(nil)),
+	}, nil
+}
+
+func (c *Client) uploadCodexResumable(
+	r *UploadCodexRequest,
+	spool *os.File,
+	body *spooledBody,
+	sessions UploadSessionStore,
+) (res *UploadCodexResponse, parseErr *CodexParseFailedError, err error) {
+	session, err := sessions.Get(body.sha256)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Once the upload has been completed, the API might have created the codex
+	// (and consumed the session) even if the response was lost, so completing a
+	// new codex is only retried if the request wasn't sent (otherwise the retry
+	// would upload a duplicate codex using a new session).
+	completing := false
+	retryable := func(err error) bool {
+		if completing && r.CodexId == "" {
+			return isUnsent(err)
+		}
+		return isTransient(err)
+	}
+
+	err = withRetriesIf("codex upload", retryable, func(attempt int) (bool, error) {
+		completing = false
+
+		// Find out where to (re)start the upload
+		var offset int64
+		if session != nil {
+			offset, err = c.getUploadSessionOffset(session)
+			if err == errUploadSessionNotFound {
+				// The session probably expired
+				log.Debugf("upload session %s not found, starting a new session", session.ID)
+				if err := sessions.Delete(body.sha256); err != nil {
+					return false, err
+				}
+				session = nil
+			} else if err != nil {
+				return false, err
+			} else if attempt == 0 {
+				log.Infof("resuming codex upload (%d of %d bytes already uploaded)", offset, body.size)
+			}
+		}
+		if session == nil {
+			session, err = c.createUploadSession(body)
+			if err != nil {
+				return false, err
+			}
+			if err := sessions.Put(body.sha256, session); err != nil {
+				return false, err
+			}
+			offset = 0
+		}
+
+		progressed := false
+		for offset < body.size {
+			offset, err = c.putUploadChunk(r, session, spool, body, offset)
+			if err != nil {
+				return progressed, err
+			}
+			progressed = true
+		}
+
+		completing = true
+		res, parseErr, err = c.completeUploadSession(session)
+		return progressed, err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The session can't be resumed once it's been completed (even if the codex
+	// failed to parse)
+	if err := sessions.Delete(body.sha256); err != nil {
+		log.WithError(err).Warn("failed to delete completed upload session")
+	}
+	return res, parseErr, nil
+}
+
+func (c *Client) createUploadSession(body *spooledBody) (*UploadSession, error) {
+	reqBody, err := json.Marshal(map[string]interface{}{
+		"size":        body.size,
+		"sha256":      body.sha256,
+		"contentType": body.contentType,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal request")
+	}
+	req, err := c.newRequest(
+		"POST",
+		"author/upload-codex/sessions",
+		"application/json",
+		bytes.NewReader(reqBody),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating upload session")
+	}
+	var resp struct {
+		SessionId string `json:"sessionId"`
+	}
+	if err := c.doUploadSessionRequest(req, &resp); err != nil {
+		return nil, errors.Wrap(err, "failed to create upload session")
+	}
+	if resp.SessionId == "" {
+		return nil, errors.New("failed to create upload session: api did not return a session id")
+	}
+	log.Debugf("created upload session: %s", resp.SessionId)
+	return &UploadSession{ID: resp.SessionId}, nil
+}
+
+// Get the number of bytes that the API has acknowledged for the session.
+func (c *Client) getUploadSessionOffset(session *UploadSession) (int64, error) {
+	req, err := c.newRequest(
+		"GET",
+		"author/upload-codex/sessions/"+session.ID,
+		"application/json",
+		nil,
+	)
+	if err != nil {
+		return 0, errors.Wrap(err, "querying upload session")
+	}
+	var resp struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := c.doUploadSessionRequest(req, &resp); err != nil {
+		if err == errUploadSessionNotFound {
+			return 0, err
+		}
+		return 0, errors.Wrap(err, "failed to query upload session")
+	}
+	return resp.Offset, nil
+}
+
+// Upload the chunk that starts at offset and return the new offset.
+func (c *Client) putUploadChunk(
+	r *UploadCodexRequest,
+	session *UploadSession,
+	spool *os.File,
+	body *spooledBody,
+	offset int64,
+) (int64, error) {
+	n := body.size - offset
+	if n > uploadChunkSize {
+		n = uploadChunkSize
+	}
+	chunk := &countingReader{r: io.NewSectionReader(spool, offset, n)}
+	if r.Progress != nil {
+		chunk.onRead = func(read int64) {
+			r.Progress(offset+read, body.size)
+		}
+	}
+	req, err := c.newRequest(
+		"PUT",
+		"author/upload-codex/sessions/"+session.ID,
+		"application/octet-stream",
+		chunk,
+	)
+	if err != nil {
+		return offset, errors.Wrap(err, "uploading codex chunk")
+	}
+	req.ContentLength = n
+	req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+n-1, body.size))
+
+	var resp struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := c.doUploadSessionRequest(req, &resp); err != nil {
+		return offset, errors.Wrapf(err, "failed to upload codex chunk (bytes %d-%d)", offset, offset+n-1)
+	}
+	if resp.Offset <= offset || resp.Offset > body.size {
+		return offset, errors.Errorf(
+			"failed to upload codex chunk: api acknowledged unexpected offset (%d)",
+			resp.Offset,
+		)
+	}
+	log.Debugf("uploaded codex chunk (%d of %d bytes acknowledged)", resp.Offset, body.size)
+	return resp.Offset, nil
+}
+
+func (c *Client) completeUploadSession(
+	session *UploadSession,
+) (*UploadCodexResponse, *CodexParseFailedError, error) {
+	req, err := c.newRequest(
+		"POST",
+		"author/upload-codex/sessions/"+session.ID+"/complete",
+		"application/json",
+		nil,
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "completing upload session")
+	}
+	httpRes, err := c.do(req)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "completing upload session (HTTP request)")
+	}
+	if err := checkTransientStatus(req.URL.Path, httpRes); err != nil {
+		return nil, nil, err
+	}
+	res := &response{req.URL.Path, httpRes}
+	defer res.Close()
+	return parseCodexUploadResponse(res)
+}
+
+func (c *Client) doUploadSessionRequest(req *http.Request, target interface{}) error {
+	httpRes, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	if err := checkTransientStatus(req.URL.Path, httpRes); err != nil {
+		return err
+	}
+	res := &response{req.URL.Path, httpRes}
+	defer res.Close()
+	if httpRes.StatusCode == http.StatusNotFound {
+		return errUploadSessionNotFound
+	}
+	statusError, err := res.StatusError()
+	if err != nil {
+		return err
+	}
+	if statusError != nil {
+		if statusError.error.Error == "ErrUnauthenticated" {
+			return ErrUnauthenticated
+		}
+		return statusError
+	}
+	return res.UnmarshalJson(target)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+	// called (if set) after every read with the total number of bytes read
+	onRead func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.onRead != nil {
+		c.onRead(c.n)
+	}
+	return n, err
+}
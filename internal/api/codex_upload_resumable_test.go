@@ -0,0 +1,168 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type memoryUploadSessionStore map[string]*UploadSession
+
+func (s memoryUploadSessionStore) Get(key string) (*UploadSession, error) {
+	return s[key], nil
+}
+
+func (s memoryUploadSessionStore) Put(key string, session *UploadSession) error {
+	s[key] = session
+	return nil
+}
+
+func (s memoryUploadSessionStore) Delete(key string) error {
+	delete(s, key)
+	return nil
+}
+
+func TestUploadCodexResumable(t *testing.T) {
+	defer func(backoff time.Duration, chunkSize int64) {
+		initialRetryBackoff, uploadChunkSize = backoff, chunkSize
+	}(initialRetryBackoff, uploadChunkSize)
+	initialRetryBackoff = time.Millisecond
+	uploadChunkSize = 1024
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var files []FileRef
+	for name, size := range map[string]int{"codex.ipynb": 100, "data.csv": 5000} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileRef{Name: name, FsPath: path})
+	}
+
+	// The server drops every third chunk
+	var received bytes.Buffer
+	chunks := 0
+	completed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/author/upload-codex/sessions":
+			_, _ = fmt.Fprint(w, `{"sessionId": "s1"}`)
+		case r.Method == "GET" && r.URL.Path == "/api/author/upload-codex/sessions/s1":
+			_, _ = fmt.Fprintf(w, `{"offset": %d}`, received.Len())
+		case r.Method == "PUT" && r.URL.Path == "/api/author/upload-codex/sessions/s1":
+			chunks++
+			if chunks%3 == 0 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			var start int
+			_, _ = fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-", &start)
+			if start != received.Len() {
+				t.Errorf("unexpected chunk offset: %d (expected %d)", start, received.Len())
+			}
+			_, _ = received.ReadFrom(r.Body)
+			_, _ = fmt.Fprintf(w, `{"offset": %d}`, received.Len())
+		case r.Method == "POST" && r.URL.Path == "/api/author/upload-codex/sessions/s1/complete":
+			completed = true
+			_, _ = fmt.Fprint(w, `{"codexId": "c1"}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	sessions := memoryUploadSessionStore{}
+	client := NewForHost(server.URL, "token")
+	res, parseErr, err := client.UploadCodexResumable(&UploadCodexRequest{
+		CodexCategoryId: "cat1",
+		Files:           files,
+	}, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	if res.CodexId != "c1" || !completed {
+		t.Errorf("upload wasn't completed")
+	}
+	if !strings.Contains(received.String(), `"codexCategoryId":"cat1"`) {
+		t.Errorf("request wasn't uploaded")
+	}
+	if len(sessions) != 0 {
+		t.Errorf("completed session wasn't deleted")
+	}
+}
+
+func TestUploadCodexResumableCompleteRetries(t *testing.T) {
+	defer func(backoff time.Duration) { initialRetryBackoff = backoff }(initialRetryBackoff)
+	initialRetryBackoff = time.Millisecond
+
+	dir, files := writeCodexTarTestDir(t, []string{"codex.ipynb", "data/a.csv"}, time.Now())
+	defer os.RemoveAll(dir)
+
+	// The first attempt to complete each session fails (after the session was
+	// consumed)
+	sessions, completions := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/author/upload-codex/sessions":
+			sessions++
+			_, _ = fmt.Fprintf(w, `{"sessionId": "s%d"}`, sessions)
+		case r.Method == "GET":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Method == "PUT":
+			n, _ := io.Copy(ioutil.Discard, r.Body)
+			var start int64
+			_, _ = fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-", &start)
+			_, _ = fmt.Fprintf(w, `{"offset": %d}`, start+n)
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/complete"):
+			completions++
+			if completions == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"codexId": "c1"}`)
+		default:
+			t.Errorf("unexpected request: %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	client := NewForHost(server.URL, "token")
+
+	// The API might have created the codex, so it's not retried
+	if _, _, err := client.UploadCodexResumable(&UploadCodexRequest{Files: files}, memoryUploadSessionStore{}); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if sessions != 1 || completions != 1 {
+		t.Errorf("expected a new codex to be completed once, got %d sessions and %d completions", sessions, completions)
+	}
+
+	sessions, completions = 0, 0
+	res, _, err := client.UploadCodexResumable(
+		&UploadCodexRequest{Files: files, CodexId: "codex1"},
+		memoryUploadSessionStore{},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CodexId != "c1" || completions != 2 {
+		t.Errorf("expected completing a replacement codex to be retried, got %d completions", completions)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Create the files (in the given order) in a new directory.
+func writeCodexTarTestDir(t *testing.T, names []string, modTime time.Time) (string, []FileRef) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []FileRef
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		mode := os.FileMode(0600)
+		if name == "bin/run.sh" {
+			mode = 0700
+		}
+		if err := ioutil.WriteFile(path, []byte("contents of "+name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileRef{Name: filepath.FromSlash(name), FsPath: path})
+	}
+	return dir, files
+}
+
+func TestWriteCodexTar(t *testing.T) {
+	names := []string{"codex.ipynb", "data/b.csv", "bin/run.sh", "data/a.csv"}
+	reversed := []string{"data/a.csv", "bin/run.sh", "data/b.csv", "codex.ipynb"}
+
+	var archives [2][]byte
+	for i, order := range [][]string{names, reversed} {
+		dir, files := writeCodexTarTestDir(t, order, time.Now().Add(time.Duration(i)*time.Hour))
+		defer os.RemoveAll(dir)
+		r := &UploadCodexRequest{Files: files, ZeroModTimes: true}
+		codexFile, err := getCodexFile(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := writeCodexTar(&buf, r, codexFile); err != nil {
+			t.Fatal(err)
+		}
+		archives[i] = buf.Bytes()
+	}
+	if !bytes.Equal(archives[0], archives[1]) {
+		t.Fatal("expected the same files to result in the same tar archive")
+	}
+
+	expected := []struct {
+		name string
+		mode int64
+	}{
+		{"bin/run.sh", 0755},
+		{"data/a.csv", 0644},
+		{"data/b.csv", 0644},
+	}
+	tarr := tar.NewReader(bytes.NewReader(archives[0]))
+	for _, e := range expected {
+		hdr, err := tarr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != e.name || hdr.Mode != e.mode {
+			t.Errorf("expected %s (mode %o), got %s (mode %o)", e.name, e.mode, hdr.Name, hdr.Mode)
+		}
+		if hdr.Uid != 0 || hdr.Gid != 0 || hdr.Uname != "" || hdr.Gname != "" {
+			t.Errorf("expected %s to have no owner", hdr.Name)
+		}
+		if !hdr.ModTime.Equal(time.Unix(0, 0)) {
+			t.Errorf("expected %s to have a zero mtime, got %s", hdr.Name, hdr.ModTime)
+		}
+	}
+	if _, err := tarr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the tar archive, got: %v", err)
+	}
+}
+
+func TestUploadCodexRetries(t *testing.T) {
+	defer func(backoff time.Duration) { initialRetryBackoff = backoff }(initialRetryBackoff)
+	initialRetryBackoff = time.Millisecond
+
+	dir, files := writeCodexTarTestDir(t, []string{"codex.ipynb", "data/a.csv"}, time.Now())
+	defer os.RemoveAll(dir)
+
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		_, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := NewForHost(server.URL, "token")
+
+	// The API might have created the codex, so it's not retried
+	if _, _, err := client.UploadCodex(&UploadCodexRequest{Files: files}); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("expected a new codex to be uploaded once, got %d attempts", attempts)
+	}
+
+	attempts = 0
+	if _, _, err := client.UploadCodex(&UploadCodexRequest{Files: files, CodexId: "codex1"}); err == nil {
+		t.Fatal("expected the upload to fail")
+	}
+	if attempts != maxRetries+1 {
+		t.Errorf("expected a replacement codex to be retried, got %d attempts", attempts)
+	}
+}
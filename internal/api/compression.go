@@ -0,0 +1,58 @@
+package api
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+)
+
+// The compression algorithms that can be used for the tar archive of a codex
+// upload (see UploadCodexRequest.Compression).
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// Compressions lists the supported compression algorithms.
+var Compressions = []string{CompressionNone, CompressionGzip}
+
+func isCompressed(compression string) bool {
+	return compression != "" && compression != CompressionNone
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Create the form part for the tar archive (compressing it if requested).
+// The returned writer must be closed to finish the part.
+func createTarFormPart(form *multipart.Writer, compression string) (io.WriteCloser, error) {
+	if !isCompressed(compression) {
+		// This is the same part that was sent before compression was supported
+		part, err := form.CreateFormFile("body", "body.tar")
+		if err != nil {
+			return nil, err
+		}
+		return nopWriteCloser{part}, nil
+	}
+	if compression != CompressionGzip {
+		return nil, errors.Errorf("unsupported compression: %s", compression)
+	}
+
+	filename, contentType := "body.tar.gz", "application/gzip"
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="body"; filename="%s"`, filename))
+	h.Set("Content-Type", contentType)
+	part, err := form.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	return gzip.NewWriter(part), nil
+}
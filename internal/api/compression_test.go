@@ -0,0 +1,125 @@
+package api
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"os"
+	"testing"
+	"time"
+)
+
+type testFormPart struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// Write the upload form for the request and return its parts (by name).
+func writeTestUploadForm(t *testing.T, r *UploadCodexRequest) map[string]*testFormPart {
+	codexFile, err := getCodexFile(r.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	if err := writeUploadCodexForm(form, r, codexFile, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]*testFormPart)
+	reader := multipart.NewReader(&buf, form.Boundary())
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[part.FormName()] = &testFormPart{
+			filename:    part.FileName(),
+			contentType: part.Header.Get("Content-Type"),
+			data:        data,
+		}
+	}
+}
+
+func TestUploadCodexFormGzip(t *testing.T) {
+	dir, files := writeCodexTarTestDir(t, []string{"codex.ipynb", "data/a.csv", "data/b.csv"}, time.Now())
+	defer os.RemoveAll(dir)
+
+	parts := writeTestUploadForm(t, &UploadCodexRequest{Files: files, Compression: CompressionGzip})
+
+	var req map[string]interface{}
+	if err := json.Unmarshal(parts["request"].data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if req["bodyCompression"] != CompressionGzip {
+		t.Errorf("expected bodyCompression to be gzip, got: %v", req["bodyCompression"])
+	}
+
+	body := parts["body"]
+	if body == nil {
+		t.Fatal("expected a body part")
+	}
+	if body.filename != "body.tar.gz" || body.contentType != "application/gzip" {
+		t.Errorf("unexpected body part: %s (%s)", body.filename, body.contentType)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarr := tar.NewReader(gz)
+	for _, name := range []string{"data/a.csv", "data/b.csv"} {
+		hdr, err := tarr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name {
+			t.Errorf("expected %s, got %s", name, hdr.Name)
+		}
+	}
+	if _, err := tarr.Next(); err != io.EOF {
+		t.Errorf("expected the end of the tar archive, got: %v", err)
+	}
+}
+
+func TestUploadCodexFormUncompressed(t *testing.T) {
+	dir, files := writeCodexTarTestDir(t, []string{"codex.ipynb", "data/a.csv"}, time.Now())
+	defer os.RemoveAll(dir)
+
+	for _, compression := range []string{"", CompressionNone} {
+		parts := writeTestUploadForm(t, &UploadCodexRequest{Files: files, Compression: compression})
+		body := parts["body"]
+		if body == nil {
+			t.Fatal("expected a body part")
+		}
+		if body.filename != "body.tar" || body.contentType != "application/octet-stream" {
+			t.Errorf(
+				"unexpected body part for compression %q: %s (%s)",
+				compression,
+				body.filename,
+				body.contentType,
+			)
+		}
+		hdr, err := tar.NewReader(bytes.NewReader(body.data)).Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != "data/a.csv" {
+			t.Errorf("unexpected tar entry: %s", hdr.Name)
+		}
+	}
+}
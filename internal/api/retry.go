@@ -0,0 +1,132 @@
+package api
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// The number of times that a request is retried after a transient failure
+// (e.g., a dropped connection or a 502 from a load balancer).
+const maxRetries = 5
+
+// The delay before the first retry (which is doubled for every subsequent
+// retry, up to maxRetryBackoff).
+var (
+	initialRetryBackoff = 1 * time.Second
+	maxRetryBackoff     = 30 * time.Second
+)
+
+// transientError marks an error that's (probably) caused by a flaky network
+// connection or an overloaded server, so the request can be retried.
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e *transientError) Unwrap() error {
+	return e.err
+}
+
+// Returns a transient error if the response has a status code that indicates a
+// temporary problem with the API (or with the proxies in front of it).
+func checkTransientStatus(route string, res *http.Response) error {
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		_ = res.Body.Close()
+		return &transientError{errors.Errorf("api endpoint (%s) returned error status: %s", route, res.Status)}
+	}
+	return nil
+}
+
+// Generate a key that identifies a logical request (so that the API can detect
+// retried requests).
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate idempotency key")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	var te *transientError
+	if errors.As(err, &te) {
+		return true
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return false
+}
+
+// Returns true if the request failed before it was sent (i.e., the connection
+// couldn't be established), so the API definitely didn't receive it.
+func isUnsent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Run an operation, retrying it with exponential backoff if it fails with a
+// transient error.
+//
+// The operation is given the number of the attempt (starting at 0) and can
+// return true (along with the error) if it made progress before failing (e.g.,
+// uploaded some chunks of a resumable upload), in which case the backoff is
+// reset.
+func withRetries(desc string, op func(attempt int) (progressed bool, err error)) error {
+	return withRetriesIf(desc, isTransient, op)
+}
+
+// Like withRetries, but only retry the errors that the retryable function
+// accepts.
+func withRetriesIf(
+	desc string,
+	retryable func(err error) bool,
+	op func(attempt int) (progressed bool, err error),
+) error {
+	backoff := initialRetryBackoff
+	retries := 0
+	for attempt := 0; ; attempt++ {
+		progressed, err := op(attempt)
+		if err == nil || !retryable(err) {
+			return err
+		}
+		if progressed {
+			backoff, retries = initialRetryBackoff, 0
+		}
+		if retries >= maxRetries {
+			return errors.Wrapf(err, "%s failed (after %d retries)", desc, retries)
+		}
+		retries++
+
+		// Add some jitter so that lots of clients don't retry at the same time
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		log.WithError(err).Warnf("%s failed, retrying in %s (retry %d of %d)", desc, delay.Round(time.Millisecond), retries, maxRetries)
+		time.Sleep(delay)
+
+		backoff *= 2
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
@@ -1,12 +1,11 @@
 package auth
 
 import (
-	"encoding/json"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/config"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
-	"os/user"
 	"path"
 	"time"
 )
@@ -14,18 +13,35 @@ import (
 type Auth struct {
 	ApiToken   string    `json:"apiToken"`
 	Expiration time.Time `json:"expirationTime"`
+	// The API host that the token was issued by.
+	Host string `json:"host,omitempty"`
+	// How the token was obtained (see LoginMethodPassword, etc.), which
+	// determines how to log in again when the token expires.
+	LoginMethod string `json:"loginMethod,omitempty"`
+
+	// The name of the profile that the auth belongs to (filled in when loaded).
+	Profile string `json:"-"`
 }
 
+const (
+	LoginMethodPassword = "password"
+	LoginMethodWeb      = "web"
+	LoginMethodToken    = "token"
+)
+
 func getApiTokenCacheFile() (string, error) {
-	currentUser, err := user.Current()
+	dir, err := config.Dir()
 	if err != nil {
 		return "", errors.Wrap(err, "unable to determine auth file")
 	}
-	dir := path.Join(currentUser.HomeDir, ".pathbird")
-	_ = os.MkdirAll(dir, 0700)
-	return path.Join(currentUser.HomeDir, ".pathbird", "auth.json"), nil
+	return path.Join(dir, "auth.json"), nil
 }
 
+// TokenEnvVar is the environment variable that can be used to supply an API
+// token directly (e.g., in CI pipelines where interactive login isn't possible).
+// If set, it takes precedence over the auth file.
+const TokenEnvVar = "PATHBIRD_TOKEN"
+
 var authCache = (*Auth)(nil)
 
 func GetAuth() (*Auth, error) {
@@ -33,41 +49,80 @@ func GetAuth() (*Auth, error) {
 		return authCache, nil
 	}
 
-	file, err := getApiTokenCacheFile()
+	f, err := readAuthFile()
 	if err != nil {
 		return nil, err
 	}
-
-	if _, err := os.Stat(file); os.IsNotExist(err) {
-		log.Debugf("authentication cache file does not exist")
-		return nil, nil
+	name, profile, err := activateProfile(f)
+	if err != nil {
+		return nil, err
 	}
 
-	fp, err := os.Open(file)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to open auth file (%s)", file)
+	if token, set := os.LookupEnv(TokenEnvVar); set && token != "" {
+		log.Debugf("using api token from %s", TokenEnvVar)
+		// We don't know when tokens from the environment expire, so we leave the
+		// expiration unset and let the API tell us if the token is invalid.
+		authCache = &Auth{
+			ApiToken: token,
+			Host:     config.PathbirdApiHost,
+			Profile:  name,
+		}
+		return authCache, nil
 	}
-	defer fp.Close()
-	data, err := ioutil.ReadAll(fp)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to read auth file (%s)", file)
+
+	if profile == nil || profile.ApiToken == "" {
+		log.Debugf("no stored credentials for profile %q", name)
+		return nil, nil
 	}
 
-	var auth Auth
-	err = json.Unmarshal(data, &auth)
-	if err != nil {
-		return nil, errors.Wrapf(err, "unable to parse auth file (%s)", file)
+	if profile.Host != config.PathbirdApiHost {
+		log.Warnf(
+			"profile %q is logged in to %s (not %s), so its credentials won't be used "+
+				"(use --profile to select a different profile)",
+			name, profile.Host, config.PathbirdApiHost,
+		)
+		return nil, nil
 	}
 
-	if time.Now().After(auth.Expiration) {
-		log.Debugf("removing expired authentication cache")
-		if err := os.Remove(file); err != nil {
-			return nil, errors.Wrapf(err, "failed to remove expired auth file (%s)", file)
+	if profile.IsExpired() {
+		log.Debugf("removing expired credentials for profile %q", name)
+		profile.ApiToken = ""
+		profile.Expiration = time.Time{}
+		if err := writeAuthFile(f); err != nil {
+			return nil, errors.Wrap(err, "failed to remove expired credentials")
 		}
+		return nil, nil
+	}
+
+	profile.Profile = name
+	authCache = profile
+	warnIfExpiringSoon(profile)
+	return profile, nil
+}
+
+// Warn about credentials that are about to expire when they're loaded (i.e.,
+// before a command uses them) so that long-running commands (e.g., uploads)
+// don't fail halfway through.
+const expirationWarningThreshold = 24 * time.Hour
+
+func warnIfExpiringSoon(a *Auth) {
+	if a.Expiration.IsZero() {
+		return
 	}
+	remaining := time.Until(a.Expiration)
+	if remaining < expirationWarningThreshold {
+		log.Warnf(
+			"your Pathbird session expires in %s (run `pbauthor auth login` to renew it)",
+			remaining.Round(time.Minute),
+		)
+	}
+}
 
-	authCache = &auth
-	return &auth, nil
+// IsExpired returns true if the auth has a known expiration time that has passed.
+// Auths without an expiration (e.g., tokens supplied via --token-stdin) never
+// expire locally.
+func (a *Auth) IsExpired() bool {
+	return !a.Expiration.IsZero() && time.Now().After(a.Expiration)
 }
 
 func GetAuthApiToken() (string, error) {
@@ -78,22 +133,81 @@ func GetAuthApiToken() (string, error) {
 	return auth.ApiToken, nil
 }
 
+// SaveAuth stores the auth in the active profile.
+// The profile is bound to the current API host; saving credentials for a
+// different host into an existing profile is an error (so that logging in to,
+// e.g., a staging server doesn't clobber production credentials).
 func SaveAuth(auth *Auth) error {
-	authCache = auth
-	file, err := getApiTokenCacheFile()
+	f, err := readAuthFile()
 	if err != nil {
 		return err
 	}
-
-	b, err := json.Marshal(auth)
+	name, profile, err := activateProfile(f)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile(file, b, 0600)
-	if err != nil {
-		return errors.Wrapf(err, "failed to write auth file (%s)", file)
+	if auth.Host == "" {
+		auth.Host = config.PathbirdApiHost
+	}
+	if profile != nil && profile.Host != "" && profile.Host != auth.Host {
+		return errors.Errorf(
+			"profile %q is bound to %s (use --profile to log in to %s with a different profile)",
+			name, profile.Host, auth.Host,
+		)
 	}
 
+	auth.Profile = name
+	f.Profiles[name] = auth
+	if err := writeAuthFile(f); err != nil {
+		return err
+	}
+	authCache = auth
 	return nil
 }
+
+// Logout revokes the active profile's token on the server and removes it from
+// the auth file. The profile itself (and the host it's bound to) is kept so
+// that logging back in uses the same host.
+//
+// The local credentials are removed even if the token can't be revoked (e.g.,
+// because the server is unreachable) since the main purpose of logging out is
+// to make sure the token can't be used from this machine anymore.
+// Returns the credentials that were removed (or nil if not logged in).
+func Logout() (*Auth, error) {
+	if token, set := os.LookupEnv(TokenEnvVar); set && token != "" {
+		return nil, errors.Errorf(
+			"using api token from %s (unset the environment variable to log out)",
+			TokenEnvVar,
+		)
+	}
+
+	f, err := readAuthFile()
+	if err != nil {
+		return nil, err
+	}
+	name, profile, err := activateProfile(f)
+	if err != nil {
+		return nil, err
+	}
+	if profile == nil || profile.ApiToken == "" {
+		return nil, nil
+	}
+	removed := *profile
+	removed.Profile = name
+
+	if !profile.IsExpired() {
+		client := api.NewForHost(profile.Host, profile.ApiToken)
+		if err := client.RevokeToken(); err != nil {
+			log.WithError(err).Warn("failed to revoke api token on the server")
+		}
+	}
+
+	profile.ApiToken = ""
+	profile.Expiration = time.Time{}
+	authCache = nil
+	if err := writeAuthFile(f); err != nil {
+		return nil, errors.Wrap(err, "failed to remove credentials")
+	}
+	return &removed, nil
+}
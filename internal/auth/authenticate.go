@@ -25,8 +25,9 @@ func AuthenticateWithPassword(email, password string) (*Auth, error) {
 	}
 
 	auth := Auth{
-		ApiToken:   resp.Token.Token,
-		Expiration: expiration,
+		ApiToken:    resp.Token.Token,
+		Expiration:  expiration,
+		LoginMethod: LoginMethodPassword,
 	}
 
 	err = SaveAuth(&auth)
@@ -37,6 +38,27 @@ func AuthenticateWithPassword(email, password string) (*Auth, error) {
 	return &auth, nil
 }
 
+// AuthenticateWithToken saves an existing API token (e.g., one issued for a
+// CI pipeline). The token isn't verified here; callers should check it against
+// the API if they need to.
+func AuthenticateWithToken(token string) (*Auth, error) {
+	if token == "" {
+		return nil, errors.New("api token must not be empty")
+	}
+
+	auth := Auth{
+		ApiToken:    token,
+		LoginMethod: LoginMethodToken,
+	}
+
+	err := SaveAuth(&auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save auth state")
+	}
+
+	return &auth, nil
+}
+
 type apiLoginRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
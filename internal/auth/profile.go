@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"encoding/json"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const DefaultProfile = "default"
+
+var profileNameRegex = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// The on-disk format of the auth file.
+// Each profile binds an API host to the credentials that were issued by it.
+type authFile struct {
+	ActiveProfile string           `json:"activeProfile,omitempty"`
+	Profiles      map[string]*Auth `json:"profiles"`
+
+	// True if the file was read from the plaintext auth file but a different
+	// credential store is configured.
+	migratePlaintext bool
+}
+
+// Older versions of pbauthor stored a single set of credentials at the top
+// level of the file. These are migrated into the default profile on read.
+type legacyAuthFile struct {
+	ApiToken   string    `json:"apiToken"`
+	Expiration time.Time `json:"expirationTime"`
+}
+
+type Profile struct {
+	Name   string
+	Active bool
+	Auth
+}
+
+func readAuthFile() (*authFile, error) {
+	store, err := getCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	data, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	f := &authFile{}
+	if data == nil {
+		// If we've switched away from the plaintext file, migrate its
+		// contents to the new store (it's removed on the next save).
+		if _, ok := store.(*fileStore); !ok {
+			data, err = readPlaintextAuthFile()
+			if err != nil {
+				return nil, err
+			}
+			f.migratePlaintext = data != nil
+		}
+	}
+
+	var legacy legacyAuthFile
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, f); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse auth file (%s)", store)
+		}
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, errors.Wrapf(err, "unable to parse auth file (%s)", store)
+		}
+	}
+	if f.Profiles == nil {
+		f.Profiles = make(map[string]*Auth)
+	}
+
+	if legacy.ApiToken != "" {
+		if _, ok := f.Profiles[DefaultProfile]; !ok {
+			log.Debugf("migrating legacy credentials to profile %q", DefaultProfile)
+			f.Profiles[DefaultProfile] = &Auth{
+				ApiToken:   legacy.ApiToken,
+				Expiration: legacy.Expiration,
+				// The legacy format didn't record a host, so assume the default.
+				Host: config.DefaultApiHost,
+			}
+		}
+	}
+
+	return f, nil
+}
+
+func writeAuthFile(f *authFile) error {
+	store, err := getCredentialStore()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	if err := store.Save(b); err != nil {
+		return err
+	}
+
+	if f.migratePlaintext {
+		file, err := getApiTokenCacheFile()
+		if err != nil {
+			return err
+		}
+		log.Infof("moved credentials from %s to %s", file, store)
+		if err := os.Remove(file); err != nil {
+			return errors.Wrapf(err, "failed to remove plaintext auth file (%s)", file)
+		}
+		f.migratePlaintext = false
+	}
+	return nil
+}
+
+func readPlaintextAuthFile() ([]byte, error) {
+	file, err := getApiTokenCacheFile()
+	if err != nil {
+		return nil, err
+	}
+	return (&fileStore{file: file}).Load()
+}
+
+// Determine the name of the active profile.
+// The --profile flag (or PATHBIRD_PROFILE) takes precedence over the profile
+// selected with `pbauthor auth profiles use`.
+func (f *authFile) activeProfileName() string {
+	if config.Profile != "" {
+		return config.Profile
+	}
+	if f.ActiveProfile != "" {
+		return f.ActiveProfile
+	}
+	return DefaultProfile
+}
+
+// Resolve the active profile and point the API host at it (unless a host was
+// explicitly requested). The returned profile is nil if it hasn't been created
+// yet.
+func activateProfile(f *authFile) (string, *Auth, error) {
+	name := f.activeProfileName()
+	if !profileNameRegex.MatchString(name) {
+		return "", nil, errors.Errorf("invalid profile name: %q", name)
+	}
+	profile := f.Profiles[name]
+	if profile != nil && profile.Host != "" && !config.ApiHostExplicit {
+		config.PathbirdApiHost = profile.Host
+	}
+	log.Debugf("using profile %q (api host: %s)", name, config.PathbirdApiHost)
+	return name, profile, nil
+}
+
+// ActivateProfile resolves the active profile so that the API host for the
+// current invocation is known before any API clients are created.
+func ActivateProfile() error {
+	f, err := readAuthFile()
+	if err != nil {
+		return err
+	}
+	_, _, err = activateProfile(f)
+	return err
+}
+
+// ListProfiles returns all known profiles, sorted by name.
+func ListProfiles() ([]Profile, error) {
+	f, err := readAuthFile()
+	if err != nil {
+		return nil, err
+	}
+	active := f.activeProfileName()
+
+	var profiles []Profile
+	for name, auth := range f.Profiles {
+		profiles = append(profiles, Profile{
+			Name:   name,
+			Active: name == active,
+			Auth:   *auth,
+		})
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+	return profiles, nil
+}
+
+// UseProfile makes the named profile the default for future invocations.
+// If the profile doesn't exist yet, it's created and bound to the requested API
+// host (or the default host if none was requested).
+func UseProfile(name string) error {
+	if !profileNameRegex.MatchString(name) {
+		return errors.Errorf("invalid profile name: %q", name)
+	}
+	f, err := readAuthFile()
+	if err != nil {
+		return err
+	}
+	if _, ok := f.Profiles[name]; !ok {
+		host := config.DefaultHost()
+		if config.ApiHostExplicit {
+			host = config.PathbirdApiHost
+		}
+		f.Profiles[name] = &Auth{Host: host}
+	}
+	f.ActiveProfile = name
+	authCache = nil
+	return writeAuthFile(f)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"github.com/mattn/go-isatty"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+var reauthenticator func(loginMethod string) (*Auth, error)
+
+// SetReauthenticator sets the function used to log in again when the API
+// rejects the stored credentials (e.g., because the token was revoked).
+// It's given the login method of the rejected credentials (see
+// LoginMethodPassword, etc.) so that the user can log in the same way again.
+func SetReauthenticator(f func(loginMethod string) (*Auth, error)) {
+	reauthenticator = f
+}
+
+var (
+	// reauthMu serializes reauthentication (so that concurrent requests that
+	// were rejected don't all prompt the user at the same time)
+	reauthMu   sync.Mutex
+	lastReauth *reauthResult
+)
+
+type reauthResult struct {
+	// The token that was rejected
+	rejected string
+	auth     *Auth
+	err      error
+}
+
+// Reauthenticate asks the user to log in again after the API rejected the
+// given token.
+// This is only possible when running in an interactive terminal.
+// It's safe to call concurrently: if the token was already replaced (or the
+// user failed to log in again), the result of that attempt is returned instead
+// of asking again.
+func Reauthenticate(rejected string) (*Auth, error) {
+	reauthMu.Lock()
+	defer reauthMu.Unlock()
+	if lastReauth != nil && lastReauth.rejected == rejected {
+		return lastReauth.auth, lastReauth.err
+	}
+	auth, err := reauthenticate()
+	lastReauth = &reauthResult{rejected: rejected, auth: auth, err: err}
+	return auth, err
+}
+
+func reauthenticate() (*Auth, error) {
+	if reauthenticator == nil {
+		return nil, errors.New("reauthentication is not available")
+	}
+	if token, set := os.LookupEnv(TokenEnvVar); set && token != "" {
+		return nil, errors.Errorf("using api token from %s", TokenEnvVar)
+	}
+	if !isInteractive() {
+		return nil, errors.New("not running in an interactive terminal")
+	}
+	var loginMethod string
+	if authCache != nil {
+		loginMethod = authCache.LoginMethod
+	}
+	authCache = nil
+	return reauthenticator(loginMethod)
+}
+
+// (overridden in tests)
+var isInteractive = IsInteractive
+
+// IsInteractive returns true if we can prompt the user for input.
+func IsInteractive() bool {
+	return isatty.IsTerminal(os.Stdin.Fd()) && isatty.IsTerminal(os.Stderr.Fd())
+}
+
+func init() {
+	api.Reauthenticate = func(rejected string) (string, error) {
+		auth, err := Reauthenticate(rejected)
+		if err != nil {
+			return "", err
+		}
+		return auth.ApiToken, nil
+	}
+}
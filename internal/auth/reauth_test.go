@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReauthenticateConcurrently(t *testing.T) {
+	defer func() {
+		reauthenticator = nil
+		isInteractive = IsInteractive
+		lastReauth = nil
+	}()
+	isInteractive = func() bool { return true }
+	var calls int32
+	reauthenticator = func(string) (*Auth, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond)
+		return &Auth{ApiToken: "new"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			auth, err := Reauthenticate("old")
+			if err != nil || auth.ApiToken != "new" {
+				t.Errorf("unexpected result: %v, %v", auth, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected the user to be asked to log in once, got %d prompts", calls)
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+)
+
+// CredentialStore persists the auth file (i.e., the serialized profiles and
+// their credentials).
+type CredentialStore interface {
+	// Load returns the stored data (or nil if nothing has been stored yet).
+	Load() ([]byte, error)
+	// Save replaces the stored data.
+	Save(data []byte) error
+	// String describes where the credentials are stored (for messages).
+	String() string
+}
+
+const (
+	StoreFile          = "file"
+	StoreEncryptedFile = "encrypted-file"
+	StoreKeyring       = "keyring"
+)
+
+var credentialStoreCache CredentialStore
+
+// Get the credential store selected by the credentials_store setting.
+func getCredentialStore() (CredentialStore, error) {
+	if credentialStoreCache != nil {
+		return credentialStoreCache, nil
+	}
+	name, err := config.CredentialsStore()
+	if err != nil {
+		return nil, err
+	}
+
+	var store CredentialStore
+	switch name {
+	case "", StoreFile:
+		file, err := getApiTokenCacheFile()
+		if err != nil {
+			return nil, err
+		}
+		store = &fileStore{file: file}
+	case StoreEncryptedFile:
+		file, err := getApiTokenCacheFile()
+		if err != nil {
+			return nil, err
+		}
+		store = &encryptedFileStore{file: file + ".enc"}
+	case StoreKeyring:
+		store, err = newKeyringStore()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf(
+			"unknown credentials store: %q (expected one of %q, %q, or %q)",
+			name, StoreFile, StoreEncryptedFile, StoreKeyring,
+		)
+	}
+	log.Debugf("using credentials store: %s", store)
+	credentialStoreCache = store
+	return store, nil
+}
+
+// The plaintext auth file (the default store).
+type fileStore struct {
+	file string
+}
+
+func (s *fileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		log.Debugf("authentication cache file does not exist")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read auth file (%s)", s.file)
+	}
+	return data, nil
+}
+
+func (s *fileStore) Save(data []byte) error {
+	err := ioutil.WriteFile(s.file, data, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write auth file (%s)", s.file)
+	}
+	return nil
+}
+
+func (s *fileStore) String() string {
+	return s.file
+}
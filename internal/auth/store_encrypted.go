@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/manifoldco/promptui"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+	"io/ioutil"
+	"os"
+)
+
+// PassphraseEnvVar can be used to supply the passphrase for the encrypted
+// credentials store non-interactively.
+const PassphraseEnvVar = "PATHBIRD_CREDENTIALS_PASSPHRASE"
+
+// scrypt parameters (as recommended by the scrypt docs for interactive logins)
+const (
+	scryptN      = 32768
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// The auth file encrypted with a key derived from a passphrase (using scrypt
+// and AES-256-GCM).
+type encryptedFileStore struct {
+	file       string
+	passphrase string
+}
+
+// The on-disk format of the encrypted file.
+type encryptedFile struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+func (s *encryptedFileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read encrypted auth file (%s)", s.file)
+	}
+
+	var ef encryptedFile
+	if err := json.Unmarshal(data, &ef); err != nil {
+		return nil, errors.Wrapf(err, "unable to parse encrypted auth file (%s)", s.file)
+	}
+	if ef.Version != 1 {
+		return nil, errors.Errorf("unsupported encrypted auth file version: %d", ef.Version)
+	}
+
+	passphrase, err := s.getPassphrase(false)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(passphrase, ef.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, ef.Nonce, ef.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("unable to decrypt auth file (wrong passphrase?)")
+	}
+	return plaintext, nil
+}
+
+func (s *encryptedFileStore) Save(data []byte) error {
+	passphrase, err := s.getPassphrase(true)
+	if err != nil {
+		return err
+	}
+
+	ef := encryptedFile{
+		Version: 1,
+		Salt:    make([]byte, 16),
+	}
+	if _, err := rand.Read(ef.Salt); err != nil {
+		return errors.Wrap(err, "failed to generate salt")
+	}
+	gcm, err := newGCM(passphrase, ef.Salt)
+	if err != nil {
+		return err
+	}
+	ef.Nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(ef.Nonce); err != nil {
+		return errors.Wrap(err, "failed to generate nonce")
+	}
+	ef.Ciphertext = gcm.Seal(nil, ef.Nonce, data, nil)
+
+	out, err := json.Marshal(&ef)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.file, out, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write encrypted auth file (%s)", s.file)
+	}
+	return nil
+}
+
+func (s *encryptedFileStore) String() string {
+	return s.file + " (encrypted)"
+}
+
+// Get the passphrase from the environment or by prompting the user.
+// When creating a new file, the user is asked to confirm the passphrase.
+func (s *encryptedFileStore) getPassphrase(confirm bool) (string, error) {
+	if s.passphrase != "" {
+		return s.passphrase, nil
+	}
+	if value, set := os.LookupEnv(PassphraseEnvVar); set && value != "" {
+		s.passphrase = value
+		return value, nil
+	}
+	if !IsInteractive() {
+		return "", errors.Errorf(
+			"a passphrase is required to access the encrypted credentials store (set %s)",
+			PassphraseEnvVar,
+		)
+	}
+
+	if _, err := os.Stat(s.file); err == nil {
+		// We're replacing an existing file, so the passphrase has to match
+		// the one that was already used.
+		confirm = false
+	}
+
+	passphrase, err := (&promptui.Prompt{
+		Label: "Credentials passphrase",
+		Mask:  '*',
+	}).Run()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to prompt passphrase")
+	}
+	if passphrase == "" {
+		return "", errors.New("passphrase must not be empty")
+	}
+	if confirm {
+		again, err := (&promptui.Prompt{
+			Label: "Confirm passphrase",
+			Mask:  '*',
+		}).Run()
+		if err != nil {
+			return "", errors.Wrap(err, "failed to prompt passphrase")
+		}
+		if again != passphrase {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	s.passphrase = passphrase
+	return passphrase, nil
+}
+
+func newGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to derive encryption key")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize cipher")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize cipher")
+	}
+	return gcm, nil
+}
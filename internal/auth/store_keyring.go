@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	keyringService = "pbauthor"
+	keyringAccount = "auth"
+)
+
+// The system keyring.
+// This uses the Secret Service API (via `secret-tool`) on Linux and the login
+// keychain (via `security`) on macOS. Other platforms aren't supported.
+type keyringStore struct {
+	tool string
+}
+
+func newKeyringStore() (*keyringStore, error) {
+	var tool string
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd":
+		tool = "secret-tool"
+	case "darwin":
+		tool = "security"
+	default:
+		return nil, errors.Errorf("the keyring credentials store is not supported on %s", runtime.GOOS)
+	}
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, errors.Errorf(
+			"the keyring credentials store requires %s (which could not be found)",
+			tool,
+		)
+	}
+	return &keyringStore{tool: tool}, nil
+}
+
+func (s *keyringStore) Load() ([]byte, error) {
+	var cmd *exec.Cmd
+	if s.tool == "security" {
+		cmd = exec.Command(s.tool, "find-generic-password", "-s", keyringService, "-a", keyringAccount, "-w")
+	} else {
+		cmd = exec.Command(s.tool, "lookup", "service", keyringService, "account", keyringAccount)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && s.isNotFound(exitErr, stdout.Bytes()) {
+			return nil, nil
+		}
+		// E.g., the keyring is locked or access was denied
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.Wrapf(err, "failed to read credentials from keyring: %s", msg)
+		}
+		return nil, errors.Wrap(err, "failed to read credentials from keyring")
+	}
+
+	// The data is base64 encoded since not all keyrings deal well with
+	// multi-line or non-ASCII secrets.
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode credentials from keyring")
+	}
+	return data, nil
+}
+
+// Determine whether the lookup failed because the item doesn't exist.
+func (s *keyringStore) isNotFound(err *exec.ExitError, stdout []byte) bool {
+	if s.tool == "security" {
+		// errSecItemNotFound
+		return err.ExitCode() == 44
+	}
+	// secret-tool doesn't distinguish between errors, but it doesn't print
+	// anything if the item doesn't exist
+	return err.ExitCode() == 1 && len(stdout) == 0
+}
+
+func (s *keyringStore) Save(data []byte) error {
+	secret := base64.StdEncoding.EncodeToString(data)
+	var cmd *exec.Cmd
+	if s.tool == "security" {
+		// `security` only accepts the secret as an argument, so the command is
+		// given on stdin (in interactive mode) to keep the secret out of the
+		// process list. None of the arguments need to be quoted (the secret is
+		// base64 encoded).
+		cmd = exec.Command(s.tool, "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf(
+			"add-generic-password -U -s %s -a %s -w %s\n",
+			keyringService, keyringAccount, secret,
+		))
+	} else {
+		cmd = exec.Command(
+			s.tool, "store", "--label=pbauthor credentials",
+			"service", keyringService, "account", keyringAccount,
+		)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to save credentials to keyring: %s", strings.TrimSpace(string(out)))
+	}
+	if s.tool == "security" && len(bytes.TrimSpace(out)) != 0 {
+		// Interactive mode exits successfully even if the command fails (but
+		// doesn't print anything if it succeeds)
+		return errors.Errorf("failed to save credentials to keyring: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s *keyringStore) String() string {
+	return "system keyring"
+}
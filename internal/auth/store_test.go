@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncryptedFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "auth.json.enc")
+	store := &encryptedFileStore{file: file, passphrase: "hunter2"}
+
+	data, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != nil {
+		t.Fatalf("expected no data before saving, got: %s", data)
+	}
+
+	if err := store.Save([]byte(`{"profiles":{}}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) == `{"profiles":{}}` {
+		t.Fatal("expected data to be encrypted")
+	}
+
+	data, err = (&encryptedFileStore{file: file, passphrase: "hunter2"}).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"profiles":{}}` {
+		t.Errorf("unexpected data: %s", data)
+	}
+
+	if _, err := (&encryptedFileStore{file: file, passphrase: "wrong"}).Load(); err == nil {
+		t.Error("expected an error when using the wrong passphrase")
+	}
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// The path of the local callback that the Pathbird login page redirects to.
+const webLoginCallbackPath = "/callback"
+
+type webLoginResult struct {
+	token     string
+	expiresAt string
+	err       error
+}
+
+// AuthenticateWithBrowser logs in using the Pathbird login page in the user's
+// web browser. This works for any account that can log in to the website
+// (including SSO accounts that don't have a Pathbird password).
+//
+// A loopback HTTP server is started on a random port and the login page is
+// instructed to redirect back to it with the issued token once the user has
+// logged in. The onURL callback is invoked with the login URL so that the
+// caller can tell the user where to go (in case the browser can't be opened).
+func AuthenticateWithBrowser(ctx context.Context, onURL func(loginURL string)) (*Auth, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start local login server")
+	}
+	defer listener.Close()
+
+	state, err := newWebLoginState()
+	if err != nil {
+		return nil, err
+	}
+
+	redirectURI := fmt.Sprintf("http://%s%s", listener.Addr().String(), webLoginCallbackPath)
+	loginURL := fmt.Sprintf(
+		"%s/auth/cli-login?%s",
+		config.PathbirdApiHost,
+		url.Values{
+			"redirect_uri": {redirectURI},
+			"state":        {state},
+		}.Encode(),
+	)
+
+	results := make(chan webLoginResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(webLoginCallbackPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("state") != state {
+			// This isn't the redirect for our login (e.g., a stray request to
+			// the port), so keep waiting for the right one
+			log.Debug("ignoring login callback with mismatched state")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintln(w, "Login failed: the login callback state did not match.")
+			return
+		}
+		result := parseWebLoginCallback(r)
+		if result.err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = fmt.Fprintf(w, "Login failed: %s\nYou can close this window.\n", result.err)
+		} else {
+			_, _ = fmt.Fprintln(w, "Logged in to pbauthor! You can close this window.")
+		}
+		select {
+		case results <- result:
+		default:
+			// We've already received a result, so ignore this one
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Debug("local login server failed")
+		}
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if onURL != nil {
+		onURL(loginURL)
+	}
+	if err := openBrowser(loginURL); err != nil {
+		log.WithError(err).Debug("failed to open web browser")
+	}
+
+	var result webLoginResult
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "timed out waiting for browser login")
+	}
+	if result.err != nil {
+		return nil, result.err
+	}
+
+	// Tokens without an expiration time don't expire
+	var expiration time.Time
+	if result.expiresAt != "" {
+		expiration, err = api.ParseDateTime(result.expiresAt)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse expiration time")
+		}
+	}
+
+	auth := Auth{
+		ApiToken:    result.token,
+		Expiration:  expiration,
+		LoginMethod: LoginMethodWeb,
+	}
+
+	err = SaveAuth(&auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to save auth state")
+	}
+
+	return &auth, nil
+}
+
+// Parse the login callback (whose state has already been checked).
+func parseWebLoginCallback(r *http.Request) webLoginResult {
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		return webLoginResult{err: errors.Errorf("authentication failed: %s", e)}
+	}
+	token := query.Get("token")
+	if token == "" {
+		return webLoginResult{err: errors.New("login callback didn't include a token")}
+	}
+	return webLoginResult{
+		token:     token,
+		expiresAt: query.Get("expiresAt"),
+	}
+}
+
+// Generate a random state parameter so that we only accept the redirect that
+// corresponds to the login we initiated.
+func newWebLoginState() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate login state")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 )
 
 const ConfigFileName = "codex.toml"
@@ -14,8 +15,12 @@ const ConfigFileName = "codex.toml"
 type Config struct {
 	Upload UploadConfig `toml:"upload"`
 	Kernel KernelConfig `toml:"kernel"`
+	// Per-environment overrides (selected with `pbauthor codex upload --env`).
+	Env map[string]EnvConfig `toml:"env,omitempty"`
 
 	configFile string
+	// The parsed config file (used to tell which keys are set, see ForEnv)
+	tree *toml.Tree
 }
 
 type UploadConfig struct {
@@ -25,26 +30,71 @@ type UploadConfig struct {
 	Name string `toml:"name"`
 	// The ID of the codex (if being re-uploaded).
 	CodexId string `toml:"codex_id,omitempty"`
+	// If set, only the files that match one of these globs are uploaded
+	// (e.g., {"*.ipynb", "data/**"}).
+	Include []string `toml:"include,omitempty"`
+	// Files that match any of these globs aren't uploaded
+	// (e.g., {"scratch/", "*.csv"}).
+	Exclude []string `toml:"exclude,omitempty"`
+	// Whether or not to skip the files listed in .gitignore files (in addition
+	// to the files listed in .pbignore files).
+	UseGitignore bool `toml:"use_gitignore,omitempty"`
+	// How to compress the uploaded files (either "none" or "gzip").
+	Compression string `toml:"compression,omitempty"`
+	// Whether or not to set the modification times of the uploaded files to
+	// the Unix epoch (so that the uploaded archive only depends on the file
+	// names and contents).
+	ZeroMtimes bool `toml:"zero_mtimes,omitempty"`
 }
 
 type KernelConfig struct {
 	// The Docker image to use when running the kernel.
-	// This overrides all other kernel config options.
+	// This overrides all other kernel config options (except for the
+	// environment variables and resource requests).
 	Image string `toml:"image,omitempty"`
 	// An array of additional (usually Debian) packages to install
 	// (e.g., {"texlive-latex-base"} if the `latex` command is required).
 	SystemPackages []string `toml:"system_packages"`
+	// The version of Python to use (e.g., "3.9").
+	PythonVersion string `toml:"python_version,omitempty"`
+	// An array of additional Python packages to install with pip
+	// (e.g., {"numpy", "pandas>=1.3"}).
+	PipPackages []string `toml:"pip_packages,omitempty"`
+	// The path (relative to the codex directory) of a pip requirements file.
+	RequirementsFile string `toml:"requirements_file,omitempty"`
+	// The path (relative to the codex directory) of a conda environment file
+	// (e.g., environment.yml).
+	EnvironmentFile string `toml:"environment_file,omitempty"`
+	// Environment variables to set in the kernel (e.g., MPLBACKEND = "Agg").
+	EnvVars map[string]string `toml:"env_vars,omitempty"`
+	// The number of CPUs to request for the kernel (e.g., 0.5 or 2).
+	Cpu float64 `toml:"cpu,omitempty"`
+	// The amount of memory to request for the kernel (e.g., "512Mi" or "4Gi").
+	Memory string `toml:"memory,omitempty"`
+	// How long cells are allowed to run before being interrupted (e.g., "10m").
+	Timeout string `toml:"timeout,omitempty"`
 }
 
+// Unmarshal parses and validates a codex config.
+// If the config is invalid, a *ValidationError is returned.
 func (c *Config) Unmarshal(data []byte) error {
-	err := toml.Unmarshal(data, c)
+	return c.unmarshal(data, "")
+}
+
+func (c *Config) unmarshal(data []byte, dir string) error {
+	if diagnostics := ValidateConfigInDir(data, dir); len(diagnostics) != 0 {
+		return &ValidationError{Diagnostics: diagnostics}
+	}
+
+	tree, err := toml.LoadBytes(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to parse codex config")
 	}
-
-	if err := c.Upload.validate(); err != nil {
-		return errors.Wrap(err, "failed to validate codex config")
+	normalizeFloats(tree, reflect.TypeOf(*c))
+	if err := tree.Unmarshal(c); err != nil {
+		return errors.Wrap(err, "failed to parse codex config")
 	}
+	c.tree = tree
 	return nil
 }
 
@@ -53,8 +103,11 @@ func (c *Config) UnmarshalFromFile(filename string) error {
 	if err != nil {
 		return errors.Wrapf(err, "failed to read codex config file (%s)", filename)
 	}
-	err = c.Unmarshal(data)
+	err = c.unmarshal(data, filepath.Dir(filename))
 	if err != nil {
+		if validationErr, ok := err.(*ValidationError); ok {
+			validationErr.File = filename
+		}
 		return err
 	}
 	c.configFile = filename
@@ -68,9 +121,9 @@ func (c *Config) Save() error {
 		return errors.New("cannot save codex config: no file selected")
 	}
 
-	data, err := toml.Marshal(c)
+	data, err := c.marshalPreservingFormat()
 	if err != nil {
-		return errors.Wrap(err, "failed to marshal codex config")
+		return err
 	}
 
 	err = ioutil.WriteFile(c.configFile, data, 0666)
@@ -81,18 +134,11 @@ func (c *Config) Save() error {
 	return nil
 }
 
-func (u *UploadConfig) validate() error {
-	if u.CodexCategory == "" {
-		return errors.New("upload.codex_category must be specified")
-	}
-	return nil
-}
-
 func GetOrInitCodexConfig(dirname string) (*Config, error) {
 	configFilePath := filepath.Join(dirname, ConfigFileName)
 	if _, err := os.Stat(configFilePath); err != nil {
 		if os.IsNotExist(err) {
-			return InitConfig(dirname)
+			return InitConfig(dirname, nil)
 		}
 		return nil, errors.Wrap(err, "unable to stat codex config file")
 	}
@@ -102,3 +148,29 @@ func GetOrInitCodexConfig(dirname string) (*Config, error) {
 	}
 	return config, nil
 }
+
+// If the config file already exists, only the keys that have changed are
+// updated (so that comments and formatting are preserved).
+func (c *Config) marshalPreservingFormat() ([]byte, error) {
+	existing, err := ioutil.ReadFile(c.configFile)
+	if os.IsNotExist(err) {
+		data, err := toml.Marshal(c)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal codex config")
+		}
+		return data, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read codex config file (%s)", c.configFile)
+	}
+
+	tree, err := toml.LoadBytes(existing)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse codex config file (%s)", c.configFile)
+	}
+	doc := parseConfigDocument(existing)
+	if err := syncConfigDocument(doc, tree, reflect.ValueOf(c).Elem(), nil); err != nil {
+		return nil, errors.Wrapf(err, "failed to update codex config file (%s)", c.configFile)
+	}
+	return doc.Bytes(), nil
+}
@@ -0,0 +1,267 @@
+package codex
+
+import (
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Update the config document so that it matches the config.
+// Only keys whose values have changed are touched.
+func syncConfigDocument(doc *configDocument, tree *toml.Tree, v reflect.Value, path []string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		tagParts := strings.Split(tag, ",")
+		fieldPath := append(path[:len(path):len(path)], tagParts[0])
+		omitEmpty := len(tagParts) > 1 && tagParts[1] == "omitempty"
+		if err := syncConfigValue(doc, tree, v.Field(i), fieldPath, omitEmpty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncConfigValue(
+	doc *configDocument,
+	tree *toml.Tree,
+	v reflect.Value,
+	path []string,
+	omitEmpty bool,
+) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		return syncConfigDocument(doc, tree, v, path)
+
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
+			subPath := append(path[:len(path):len(path)], k.String())
+			if err := syncConfigValue(doc, tree, v.MapIndex(k), subPath, false); err != nil {
+				return err
+			}
+		}
+		// Remove any (leaf) entries that were removed from the map
+		if sub, ok := tree.GetPath(path).(*toml.Tree); ok {
+			for _, k := range sub.Keys() {
+				if _, isTable := sub.GetPath([]string{k}).(*toml.Tree); isTable {
+					continue
+				}
+				if !v.MapIndex(reflect.ValueOf(k)).IsValid() {
+					if err := doc.Unset(append(path[:len(path):len(path)], k)); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+
+	default:
+		existing := tree.GetPath(path)
+		if isZeroValue(v) {
+			if existing != nil && omitEmpty {
+				return doc.Unset(path)
+			}
+			if existing == nil {
+				// Don't add noise (e.g., empty arrays) to the file
+				return nil
+			}
+		}
+		if existing != nil && reflect.DeepEqual(existing, toTomlValue(v)) {
+			return nil
+		}
+		return doc.Set(path, v.Interface())
+	}
+}
+
+func isZeroValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+	}
+}
+
+// Convert a Go value into the representation used by go-toml (so that it can
+// be compared with values from a toml.Tree).
+func toTomlValue(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int64:
+		return v.Int()
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			items[i] = toTomlValue(v.Index(i))
+		}
+		return items
+	default:
+		return v.Interface()
+	}
+}
+
+// Resolve a dotted key (e.g., "upload.name") to the type of the config value.
+func resolveConfigKey(key string) ([]string, reflect.Type, error) {
+	path, err := splitDottedKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	t := reflect.TypeOf(Config{})
+	for i, part := range path {
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := tomlFields(t)
+			f, ok := fields[part]
+			if !ok {
+				msg := "unknown key " + strings.Join(path[:i+1], ".")
+				if suggestion := closestKey(part, fields); suggestion != "" {
+					msg += " (did you mean " + suggestion + "?)"
+				}
+				return nil, nil, errors.New(msg)
+			}
+			t = f.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil, nil, errors.Errorf("%s is not a table", strings.Join(path[:i], "."))
+		}
+	}
+	if t.Kind() == reflect.Struct || t.Kind() == reflect.Map {
+		return nil, nil, errors.Errorf("%s is a table (not a value)", key)
+	}
+	return path, t, nil
+}
+
+// Parse a value given on the command line into the type expected by the config.
+// Arrays can be given either as TOML arrays (e.g., ["a", "b"]) or as a
+// comma-separated list.
+func parseConfigValue(value string, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Slice:
+		var items []string
+		if strings.HasPrefix(strings.TrimSpace(value), "[") {
+			tree, err := toml.Load("value = " + value)
+			if err != nil {
+				return nil, errors.Wrap(err, "invalid array")
+			}
+			raw, ok := tree.Get("value").([]interface{})
+			if !ok {
+				return nil, errors.New("invalid array")
+			}
+			for _, item := range raw {
+				items = append(items, toString(item))
+			}
+		} else if value != "" {
+			for _, item := range strings.Split(value, ",") {
+				items = append(items, strings.TrimSpace(item))
+			}
+		}
+		result := reflect.MakeSlice(t, 0, len(items))
+		for _, item := range items {
+			parsed, err := parseConfigValue(item, t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			result = reflect.Append(result, reflect.ValueOf(parsed).Convert(t.Elem()))
+		}
+		return result.Interface(), nil
+	default:
+		return nil, errors.Errorf("unsupported value type: %s", t)
+	}
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	encoded, err := encodeTomlValue(v)
+	if err != nil {
+		return ""
+	}
+	return encoded
+}
+
+// GetConfigValue returns the value of a key in a codex config file (and whether
+// or not it's set). Strings are returned as-is and other values are formatted
+// as TOML (e.g., ["a", "b"]).
+func GetConfigValue(filename string, key string) (string, bool, error) {
+	path, _, err := resolveConfigKey(key)
+	if err != nil {
+		return "", false, err
+	}
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to parse codex config file (%s)", filename)
+	}
+	value := tree.GetPath(path)
+	if value == nil {
+		return "", false, nil
+	}
+	return toString(value), true, nil
+}
+
+// SetConfigValue sets a key in a codex config file (preserving comments and
+// formatting). The file is only written if the result is a valid config.
+func SetConfigValue(filename string, key string, value string) error {
+	path, t, err := resolveConfigKey(key)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseConfigValue(value, t)
+	if err != nil {
+		return errors.Wrapf(err, "invalid value for %s", key)
+	}
+	return editConfigFile(filename, func(doc *configDocument) error {
+		return doc.Set(path, parsed)
+	})
+}
+
+// UnsetConfigValue removes a key from a codex config file (preserving comments
+// and formatting). The file is only written if the result is a valid config.
+func UnsetConfigValue(filename string, key string) error {
+	path, _, err := resolveConfigKey(key)
+	if err != nil {
+		return err
+	}
+	return editConfigFile(filename, func(doc *configDocument) error {
+		return doc.Unset(path)
+	})
+}
+
+func editConfigFile(filename string, edit func(doc *configDocument) error) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read codex config file (%s)", filename)
+	}
+	doc := parseConfigDocument(data)
+	if err := edit(doc); err != nil {
+		return err
+	}
+	if diagnostics := ValidateConfigInDir(doc.Bytes(), filepath.Dir(filename)); len(diagnostics) != 0 {
+		return &ValidationError{File: filename, Diagnostics: diagnostics}
+	}
+	if err := ioutil.WriteFile(filename, doc.Bytes(), 0666); err != nil {
+		return errors.Wrapf(err, "failed to save codex config file (%s)", filename)
+	}
+	return nil
+}
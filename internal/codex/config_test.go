@@ -1,6 +1,10 @@
 package codex
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +30,264 @@ func TestUnmarshallConfig(t *testing.T) {
 		t.Errorf("unexpected value for Upload.CodexCategory: %s", config.Upload.CodexCategory)
 	}
 }
+
+func TestValidateConfig(t *testing.T) {
+	src := []byte(`[upload]
+codex_category = "foo"
+name = 42
+
+[kernel]
+system_pakages = ["texlive-latex-base"]
+`)
+	diagnostics := ValidateConfig(src)
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected two diagnostics, got: %v", diagnostics)
+	}
+
+	d := diagnostics[0]
+	if d.Line != 3 || d.Column != 1 || d.Key != "upload.name" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+
+	d = diagnostics[1]
+	if d.Line != 6 || d.Column != 1 || d.Key != "kernel.system_pakages" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	if d.Message != "unknown key kernel.system_pakages (did you mean system_packages?)" {
+		t.Errorf("unexpected message: %s", d.Message)
+	}
+}
+
+func TestValidateConfigMissingCategory(t *testing.T) {
+	diagnostics := ValidateConfig([]byte("[upload]\nname = \"foo\"\n"))
+	if len(diagnostics) != 1 || diagnostics[0].Key != "upload.codex_category" {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+	if diagnostics[0].Line != 1 {
+		t.Errorf("expected diagnostic to point at the upload table: %+v", diagnostics[0])
+	}
+}
+
+func TestConfigForEnv(t *testing.T) {
+	config := &Config{}
+	err := config.Unmarshal([]byte(`[upload]
+name = "Intro to Foo-ology"
+
+[kernel]
+system_packages = ["make"]
+
+[env.staging.upload]
+codex_category = "staging-category"
+
+[env.production]
+upload.codex_category = "production-category"
+kernel.system_packages = []
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := config.ForEnv(""); err == nil {
+		t.Error("expected an error when no environment is selected")
+	}
+	if _, err := config.ForEnv("dev"); err == nil {
+		t.Error("expected an error for an unknown environment")
+	}
+
+	staging, err := config.ForEnv("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.Upload.CodexCategory != "staging-category" || staging.Upload.Name != "Intro to Foo-ology" {
+		t.Errorf("unexpected upload config for staging: %+v", staging.Upload)
+	}
+	if len(staging.Kernel.SystemPackages) != 1 {
+		t.Errorf("unexpected kernel config for staging: %+v", staging.Kernel)
+	}
+
+	production, err := config.ForEnv("production")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if production.Upload.CodexCategory != "production-category" {
+		t.Errorf("unexpected upload config for production: %+v", production.Upload)
+	}
+	if len(production.Kernel.SystemPackages) != 0 {
+		t.Errorf("unexpected kernel config for production: %+v", production.Kernel)
+	}
+}
+
+func TestConfigForEnvOverrides(t *testing.T) {
+	config := &Config{}
+	err := config.Unmarshal([]byte(`[upload]
+codex_category = "production-category"
+codex_id = "production-codex"
+use_gitignore = true
+
+[env.staging.upload]
+codex_category = "staging-category"
+use_gitignore = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	staging, err := config.ForEnv("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if staging.Upload.CodexId != "" {
+		t.Errorf("expected the staging environment not to use the base codex id, got: %s", staging.Upload.CodexId)
+	}
+	if staging.Upload.UseGitignore {
+		t.Error("expected the staging environment to override upload.use_gitignore")
+	}
+
+	base, err := config.ForEnv("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.Upload.CodexId != "production-codex" || !base.Upload.UseGitignore {
+		t.Errorf("unexpected base upload config: %+v", base.Upload)
+	}
+}
+
+func TestValidateConfigMissingEnvCategory(t *testing.T) {
+	diagnostics := ValidateConfig([]byte("[env.staging.upload]\nname = \"foo\"\n"))
+	if len(diagnostics) != 1 || diagnostics[0].Key != "env.staging.upload.codex_category" {
+		t.Fatalf("unexpected diagnostics: %v", diagnostics)
+	}
+}
+
+func TestValidateConfigKernel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "requirements.txt"), []byte("numpy\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	src := []byte(`[upload]
+codex_category = "foo"
+
+[kernel]
+python_version = "3.9"
+pip_packages = ["pandas>=1.3"]
+requirements_file = "requirements.txt"
+environment_file = "environment.yml"
+
+[env.staging.kernel]
+python_version = "latest"
+requirements_file = "../requirements.txt"
+`)
+	diagnostics := ValidateConfigInDir(src, dir)
+	if len(diagnostics) != 3 {
+		t.Fatalf("expected three diagnostics, got: %v", diagnostics)
+	}
+	if d := diagnostics[0]; d.Line != 8 || d.Key != "kernel.environment_file" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	if d := diagnostics[1]; d.Line != 11 || d.Key != "env.staging.kernel.python_version" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	if d := diagnostics[2]; d.Line != 12 || d.Key != "env.staging.kernel.requirements_file" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+}
+
+func TestUnmarshalKernelResources(t *testing.T) {
+	config := &Config{}
+	err := config.Unmarshal([]byte(`[upload]
+codex_category = "foo"
+
+[kernel]
+cpu = 2
+memory = "4Gi"
+timeout = "10m"
+
+[kernel.env_vars]
+MPLBACKEND = "Agg"
+
+[env.staging.kernel.env_vars]
+DEBUG = "1"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Kernel.Cpu != 2 {
+		t.Errorf("unexpected value for Kernel.Cpu: %v", config.Kernel.Cpu)
+	}
+	if memory, err := config.Kernel.MemoryBytes(); err != nil || memory != 4<<30 {
+		t.Errorf("unexpected value for Kernel.MemoryBytes(): %v (%v)", memory, err)
+	}
+
+	staging, err := config.ForEnv("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(staging.Kernel.EnvVars) != 2 || staging.Kernel.EnvVars["MPLBACKEND"] != "Agg" {
+		t.Errorf("unexpected value for Kernel.EnvVars: %v", staging.Kernel.EnvVars)
+	}
+}
+
+func TestValidateConfigKernelResources(t *testing.T) {
+	diagnostics := ValidateConfig([]byte(`[upload]
+codex_category = "foo"
+
+[kernel]
+memory = "lots"
+timeout = "10"
+env_vars = { "NOT-VALID" = "1" }
+
+[env.staging.kernel]
+cpu = 0
+`))
+	if len(diagnostics) != 4 {
+		t.Fatalf("expected four diagnostics, got: %v", diagnostics)
+	}
+	if d := diagnostics[3]; d.Key != "env.staging.kernel.cpu" {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+}
+
+func TestValidateConfigKernelFileExcluded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"requirements.txt": "numpy\n",
+		"environment.yml":  "name: codex\n",
+		IgnoreFileName:     "environment.yml\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src := []byte(`[upload]
+codex_category = "foo"
+
+[kernel]
+requirements_file = "requirements.txt"
+environment_file = "environment.yml"
+
+[env.staging.upload]
+include = ["*.ipynb"]
+`)
+	diagnostics := ValidateConfigInDir(src, dir)
+	if len(diagnostics) != 2 {
+		t.Fatalf("expected two diagnostics, got: %v", diagnostics)
+	}
+	// Not included by env.staging.upload.include
+	if d := diagnostics[0]; d.Line != 5 || d.Key != "kernel.requirements_file" || !strings.Contains(d.Message, "staging") {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+	// Ignored by .pbignore
+	if d := diagnostics[1]; d.Line != 6 || d.Key != "kernel.environment_file" || strings.Contains(d.Message, "staging") {
+		t.Errorf("unexpected diagnostic: %+v", d)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/pathbird/pbauthor/internal/graphql/transport"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 	time "time"
 )
@@ -42,6 +43,17 @@ func WaitForKernelBuildCompleted(
 	ctx context.Context,
 	client *graphql.Client,
 	codexId string,
+) (*KernelSpec, error) {
+	return WaitForKernelBuildCompletedWithLog(ctx, client, codexId, os.Stdout)
+}
+
+// WaitForKernelBuildCompletedWithLog is like WaitForKernelBuildCompleted, but
+// writes the build log to buildLog (rather than stdout).
+func WaitForKernelBuildCompletedWithLog(
+	ctx context.Context,
+	client *graphql.Client,
+	codexId string,
+	buildLog io.Writer,
 ) (*KernelSpec, error) {
 	// If the kernel is built right away, it indicates that we're using a previous kernel build
 	// so we can skip waiting for the build to complete (and in particular we don't want to
@@ -64,7 +76,7 @@ func WaitForKernelBuildCompleted(
 		}
 		offset += int64(len(spec.BuildLog))
 		for _, logentry := range spec.BuildLog {
-			_, _ = os.Stdout.WriteString(logentry)
+			_, _ = io.WriteString(buildLog, logentry)
 		}
 		if spec.BuildStatus != "pending" {
 			return spec, nil
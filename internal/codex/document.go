@@ -0,0 +1,434 @@
+package codex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// configDocument is a minimal line-based TOML editor.
+// Unlike round-tripping through toml.Marshal, it preserves comments,
+// formatting, and key order, which means that both humans and pbauthor can
+// edit codex.toml without clobbering each other's changes.
+//
+// Only the subset of TOML that's needed for codex config files is supported:
+// keys can be set, replaced, and removed, but values inside inline tables and
+// arrays of tables can't be edited.
+type configDocument struct {
+	lines []string
+}
+
+// A key/value pair in the document.
+type documentEntry struct {
+	// The full path of the key (including the table it's in).
+	path []string
+	// The table that the key was defined in.
+	table []string
+	// The (0-based) first and last lines of the entry.
+	start, end int
+	// The text before the value on the first line (indentation, key, and "=")
+	// and after the value on the last line (e.g., a trailing comment).
+	prefix, suffix string
+}
+
+// A table header in the document.
+type documentTable struct {
+	path   []string
+	line   int
+	isList bool
+}
+
+func parseConfigDocument(data []byte) *configDocument {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return &configDocument{}
+	}
+	return &configDocument{lines: strings.Split(text, "\n")}
+}
+
+func (d *configDocument) Bytes() []byte {
+	if len(d.lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(d.lines, "\n") + "\n")
+}
+
+// Scan the document for tables and key/value pairs.
+func (d *configDocument) scan() ([]documentEntry, []documentTable, error) {
+	var entries []documentEntry
+	var tables []documentTable
+	var current []string
+	inList := false
+
+	for i := 0; i < len(d.lines); i++ {
+		line := d.lines[i]
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			isList := strings.HasPrefix(trimmed, "[[")
+			name := strings.TrimLeft(trimmed, "[")
+			end := strings.Index(name, "]")
+			if end < 0 {
+				return nil, nil, errors.Errorf("line %d: invalid table header", i+1)
+			}
+			path, err := splitDottedKey(name[:end])
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "line %d", i+1)
+			}
+			tables = append(tables, documentTable{path: path, line: i, isList: isList})
+			current = path
+			inList = isList
+			continue
+		}
+
+		eq := indexOutsideQuotes(line, '=')
+		if eq < 0 {
+			return nil, nil, errors.Errorf("line %d: expected key = value", i+1)
+		}
+		key, err := splitDottedKey(line[:eq])
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "line %d", i+1)
+		}
+
+		// Find where the value starts and ends (it may span multiple lines)
+		valueStart := eq + 1
+		for valueStart < len(line) && (line[valueStart] == ' ' || line[valueStart] == '\t') {
+			valueStart++
+		}
+		rest := strings.Join(d.lines[i:], "\n")
+		valueEnd, err := scanTomlValue(rest, valueStart)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "line %d", i+1)
+		}
+		endLine := i + strings.Count(rest[:valueEnd], "\n")
+		lastLineStart := strings.LastIndex(rest[:valueEnd], "\n") + 1
+
+		if !inList {
+			entries = append(entries, documentEntry{
+				path:   append(append([]string{}, current...), key...),
+				table:  current,
+				start:  i,
+				end:    endLine,
+				prefix: line[:valueStart],
+				suffix: d.lines[endLine][valueEnd-lastLineStart:],
+			})
+		}
+		i = endLine
+	}
+	return entries, tables, nil
+}
+
+// Set the value of a key (adding it, and the table it belongs to, if needed).
+func (d *configDocument) Set(path []string, value interface{}) error {
+	encoded, err := encodeTomlValue(value)
+	if err != nil {
+		return errors.Wrapf(err, "invalid value for %s", strings.Join(path, "."))
+	}
+
+	entries, tables, err := d.scan()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if pathEqual(e.path, path) {
+			d.splice(e.start, e.end+1, e.prefix+encoded+e.suffix)
+			return nil
+		}
+		if isPathPrefix(e.path, path) {
+			return errors.Errorf(
+				"cannot set %s: %s is not a table (line %d)",
+				strings.Join(path, "."), strings.Join(e.path, "."), e.start+1,
+			)
+		}
+	}
+
+	table, key := path[:len(path)-1], path[len(path)-1]
+	line := fmt.Sprintf("%s = %s", formatTomlKey(key), encoded)
+
+	// Insert the key after the last key in its table
+	insertAt := -1
+	for _, e := range entries {
+		if pathEqual(e.table, table) {
+			insertAt = e.end + 1
+		}
+	}
+	if insertAt < 0 {
+		// The table might be defined implicitly using dotted keys
+		// (e.g., `upload.codex_id = "..."` in the [env.staging] table)
+		for _, e := range entries {
+			if pathEqual(e.path[:len(e.path)-1], table) {
+				insertAt = e.end + 1
+				line = fmt.Sprintf("%s = %s", formatTomlPath(path[len(e.table):]), encoded)
+			}
+		}
+	}
+	if insertAt < 0 {
+		for _, t := range tables {
+			if pathEqual(t.path, table) && !t.isList {
+				insertAt = t.line + 1
+			}
+		}
+	}
+	if insertAt < 0 && len(table) == 0 {
+		// Root keys have to come before the first table
+		insertAt = 0
+		if len(tables) > 0 {
+			insertAt = tables[0].line
+		}
+	}
+	if insertAt >= 0 {
+		d.splice(insertAt, insertAt, line)
+		return nil
+	}
+
+	// The table doesn't exist yet, so add it to the end of the document
+	var newLines []string
+	if len(d.lines) > 0 && strings.TrimSpace(d.lines[len(d.lines)-1]) != "" {
+		newLines = append(newLines, "")
+	}
+	newLines = append(newLines, fmt.Sprintf("[%s]", formatTomlPath(table)), line)
+	d.lines = append(d.lines, newLines...)
+	return nil
+}
+
+// Unset removes a key. It's not an error if the key doesn't exist.
+func (d *configDocument) Unset(path []string) error {
+	entries, _, err := d.scan()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if pathEqual(e.path, path) {
+			d.splice(e.start, e.end+1)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Replace lines [start, end) with the given lines.
+func (d *configDocument) splice(start, end int, lines ...string) {
+	newLines := make([]string, 0, len(d.lines)-(end-start)+len(lines))
+	newLines = append(newLines, d.lines[:start]...)
+	newLines = append(newLines, lines...)
+	newLines = append(newLines, d.lines[end:]...)
+	d.lines = newLines
+}
+
+// Find the end of the TOML value that starts at s[i] (the value may span
+// multiple lines if it's an array or multi-line string).
+func scanTomlValue(s string, i int) (int, error) {
+	if i >= len(s) {
+		return 0, errors.New("missing value")
+	}
+	switch s[i] {
+	case '"', '\'':
+		return scanTomlString(s, i)
+	case '[', '{':
+		depth := 0
+		for i < len(s) {
+			switch c := s[i]; c {
+			case '"', '\'':
+				end, err := scanTomlString(s, i)
+				if err != nil {
+					return 0, err
+				}
+				i = end
+				continue
+			case '#':
+				// Comments are allowed between array elements
+				for i < len(s) && s[i] != '\n' {
+					i++
+				}
+				continue
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					return i + 1, nil
+				}
+			}
+			i++
+		}
+		return 0, errors.New("unterminated array or inline table")
+	default:
+		end := i
+		for end < len(s) && s[end] != '\n' && s[end] != '#' {
+			end++
+		}
+		return len(strings.TrimRight(s[:end], " \t")), nil
+	}
+}
+
+func scanTomlString(s string, i int) (int, error) {
+	quote := s[i]
+	if strings.HasPrefix(s[i:], strings.Repeat(string(quote), 3)) {
+		delim := strings.Repeat(string(quote), 3)
+		j := i + 3
+		for j < len(s) {
+			if quote == '"' && s[j] == '\\' {
+				j += 2
+				continue
+			}
+			if strings.HasPrefix(s[j:], delim) {
+				return j + 3, nil
+			}
+			j++
+		}
+		return 0, errors.New("unterminated multi-line string")
+	}
+	for j := i + 1; j < len(s) && s[j] != '\n'; j++ {
+		if quote == '"' && s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == quote {
+			return j + 1, nil
+		}
+	}
+	return 0, errors.New("unterminated string")
+}
+
+// Find the first occurrence of c that's not inside a quoted string.
+func indexOutsideQuotes(s string, c byte) int {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if quote == '"' && s[i] == '\\' {
+				i++
+			} else if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == c:
+			return i
+		}
+	}
+	return -1
+}
+
+// Split a (possibly quoted) dotted key into its parts.
+func splitDottedKey(s string) ([]string, error) {
+	var parts []string
+	for {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return nil, errors.New("empty key")
+		}
+		var part string
+		if s[0] == '"' || s[0] == '\'' {
+			end, err := scanTomlString(s, 0)
+			if err != nil {
+				return nil, err
+			}
+			part = s[1 : end-1]
+			if s[0] == '"' {
+				unquoted, err := strconv.Unquote(s[:end])
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid key: %s", s[:end])
+				}
+				part = unquoted
+			}
+			s = strings.TrimSpace(s[end:])
+		} else {
+			end := strings.IndexByte(s, '.')
+			if end < 0 {
+				end = len(s)
+			}
+			part = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		parts = append(parts, part)
+		if s == "" {
+			return parts, nil
+		}
+		if s[0] != '.' {
+			return nil, errors.Errorf("invalid key: %s", s)
+		}
+		s = s[1:]
+	}
+}
+
+func formatTomlKey(key string) string {
+	for _, c := range key {
+		isBare := c == '_' || c == '-' ||
+			('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+		if !isBare {
+			return encodeTomlString(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+func formatTomlPath(path []string) string {
+	keys := make([]string, len(path))
+	for i, k := range path {
+		keys[i] = formatTomlKey(k)
+	}
+	return strings.Join(keys, ".")
+}
+
+func encodeTomlValue(value interface{}) (string, error) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return encodeTomlString(v.String()), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := encodeTomlValue(v.Index(i).Interface())
+			if err != nil {
+				return "", err
+			}
+			items[i] = item
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	default:
+		return "", errors.Errorf("unsupported value type: %T", value)
+	}
+}
+
+// JSON string escapes are a subset of TOML basic string escapes.
+func encodeTomlString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+func pathEqual(a, b []string) bool {
+	return len(a) == len(b) && isPathPrefix(a, b)
+}
+
+// Returns true if a is a prefix of b.
+func isPathPrefix(a, b []string) bool {
+	if len(a) > len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
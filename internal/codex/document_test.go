@@ -0,0 +1,89 @@
+package codex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigDocumentPreservesComments(t *testing.T) {
+	doc := parseConfigDocument([]byte(`# Settings for the codex
+[upload]
+codex_category = "foo" # the category
+name = "Intro to Foo-ology"
+
+[kernel]
+# Needed for LaTeX
+system_packages = [
+  "texlive-latex-base",
+]
+`))
+
+	if err := doc.Set([]string{"upload", "codex_category"}, "bar"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set([]string{"upload", "codex_id"}, "abc123"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Set([]string{"kernel", "system_packages"}, []string{"make"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := doc.Unset([]string{"upload", "name"}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# Settings for the codex
+[upload]
+codex_category = "bar" # the category
+codex_id = "abc123"
+
+[kernel]
+# Needed for LaTeX
+system_packages = ["make"]
+`
+	if got := string(doc.Bytes()); got != expected {
+		t.Errorf("unexpected document:\n%s", got)
+	}
+}
+
+func TestConfigDocumentAddTable(t *testing.T) {
+	doc := parseConfigDocument([]byte("[upload]\ncodex_category = \"foo\"\n"))
+	if err := doc.Set([]string{"kernel", "image"}, "python:3.9"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "[upload]\ncodex_category = \"foo\"\n\n[kernel]\nimage = \"python:3.9\"\n"
+	if got := string(doc.Bytes()); got != expected {
+		t.Errorf("unexpected document:\n%s", got)
+	}
+}
+
+func TestSaveConfigPreservesComments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "codex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := "# My codex\n[upload]\ncodex_category = \"foo\" # the category\nname = \"bar\"\n"
+	file := filepath.Join(dir, ConfigFileName)
+	if err := ioutil.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	if err := config.UnmarshalFromFile(file); err != nil {
+		t.Fatal(err)
+	}
+	config.Upload.CodexId = "abc123"
+	if err := config.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := src + "codex_id = \"abc123\"\n"; string(data) != expected {
+		t.Errorf("unexpected config file:\n%s", data)
+	}
+}
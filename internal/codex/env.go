@@ -0,0 +1,135 @@
+package codex
+
+import (
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// EnvConfig overrides the upload and kernel config for a specific environment
+// (e.g., a staging course and a production course), for example:
+//
+//	[env.staging.upload]
+//	codex_category = "..."
+//
+// Only the keys that are set in the environment table are overridden, except
+// for upload.codex_id (each environment uploads its own codex).
+type EnvConfig struct {
+	Upload UploadConfig `toml:"upload"`
+	Kernel KernelConfig `toml:"kernel"`
+}
+
+// EnvNames returns the names of all of the environments defined in the config.
+func (c *Config) EnvNames() []string {
+	var names []string
+	for name := range c.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForEnv returns the effective config for an environment (i.e., the upload and
+// kernel config with the environment's overrides applied).
+// The empty string selects the base config.
+//
+// The returned config can't be saved (use SetCodexId on the original config
+// instead).
+func (c *Config) ForEnv(name string) (*Config, error) {
+	effective := *c
+	effective.Env = nil
+	effective.configFile = ""
+
+	if name == "" {
+		if c.Upload.CodexCategory == "" && len(c.Env) > 0 {
+			return nil, errors.Errorf(
+				"upload.codex_category isn't set, so an environment must be selected (one of: %s)",
+				strings.Join(c.EnvNames(), ", "),
+			)
+		}
+		return &effective, nil
+	}
+
+	env, ok := c.Env[name]
+	if !ok {
+		if len(c.Env) == 0 {
+			return nil, errors.Errorf("unknown environment: %s (no environments are defined)", name)
+		}
+		return nil, errors.Errorf(
+			"unknown environment: %s (expected one of: %s)",
+			name,
+			strings.Join(c.EnvNames(), ", "),
+		)
+	}
+	var envTree *toml.Tree
+	if c.tree != nil {
+		envTree, _ = c.tree.GetPath([]string{"env", name}).(*toml.Tree)
+	}
+	overlay(reflect.ValueOf(&effective.Upload).Elem(), reflect.ValueOf(env.Upload), subTree(envTree, "upload"))
+	overlay(reflect.ValueOf(&effective.Kernel).Elem(), reflect.ValueOf(env.Kernel), subTree(envTree, "kernel"))
+	// Otherwise, the first upload to a new environment would replace the codex
+	// of the base config
+	effective.Upload.CodexId = env.Upload.CodexId
+	return &effective, nil
+}
+
+// SetCodexId records the ID of the codex that was uploaded for an environment
+// (or for the base config if the environment is the empty string).
+func (c *Config) SetCodexId(env string, codexId string) {
+	if env == "" {
+		c.Upload.CodexId = codexId
+		return
+	}
+	envConfig := c.Env[env]
+	envConfig.Upload.CodexId = codexId
+	c.Env[env] = envConfig
+}
+
+// Copy all of the fields that are set in src into dst.
+// Arrays are considered set if they're present at all (so that an environment
+// can override them with an empty array) and tables are merged. Other values
+// are considered set if they're present in the tree (so that an environment
+// can override them with, e.g., false) or if they aren't the zero value.
+func overlay(dst reflect.Value, src reflect.Value, tree *toml.Tree) {
+	for i := 0; i < src.NumField(); i++ {
+		f := src.Field(i)
+		key := strings.Split(src.Type().Field(i).Tag.Get("toml"), ",")[0]
+		switch f.Kind() {
+		case reflect.Struct:
+			overlay(dst.Field(i), f, subTree(tree, key))
+			continue
+		case reflect.Map:
+			if f.Len() == 0 {
+				continue
+			}
+			merged := reflect.MakeMap(f.Type())
+			for _, m := range []reflect.Value{dst.Field(i), f} {
+				for _, k := range m.MapKeys() {
+					merged.SetMapIndex(k, m.MapIndex(k))
+				}
+			}
+			dst.Field(i).Set(merged)
+			continue
+		case reflect.Slice, reflect.Ptr:
+			if f.IsNil() {
+				continue
+			}
+		default:
+			if isZeroValue(f) && (tree == nil || !tree.Has(key)) {
+				continue
+			}
+		}
+		dst.Field(i).Set(f)
+	}
+}
+
+// Get the table at key (or nil if there isn't one).
+func subTree(tree *toml.Tree, key string) *toml.Tree {
+	if tree == nil {
+		return nil
+	}
+	sub, _ := tree.GetPath([]string{key}).(*toml.Tree)
+	return sub
+}
@@ -0,0 +1,36 @@
+package codex
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FindCodexDirs finds the codex directories (i.e., the directories that contain
+// a codex.toml file) below root (including root itself).
+// Hidden directories aren't searched, and neither are the subdirectories of
+// codex directories (since they're part of the codex).
+func FindCodexDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if _, err := os.Stat(filepath.Join(path, ConfigFileName)); err == nil {
+			dirs = append(dirs, path)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search for codex directories in %s", root)
+	}
+	return dirs, nil
+}
@@ -0,0 +1,44 @@
+package codex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindCodexDirs(t *testing.T) {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{
+		"unit1/lab",
+		"unit1/lab/solutions", // nested in a codex
+		"unit2",
+		".git/unit3",  // hidden
+		"unit4/notes", // not a codex
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if dir == "unit4/notes" {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, dir, ConfigFileName), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs, err := FindCodexDirs(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(root, "unit1", "lab"), filepath.Join(root, "unit2")}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Errorf("expected %v, got: %v", expected, dirs)
+	}
+}
@@ -0,0 +1,234 @@
+package codex
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file that lists the files that shouldn't
+// be uploaded as part of the codex (using the same syntax as .gitignore).
+const IgnoreFileName = ".pbignore"
+
+const gitignoreFileName = ".gitignore"
+
+// A single pattern from an ignore file (or an upload.include/upload.exclude
+// glob).
+type ignoreRule struct {
+	// The directory (relative to the codex directory, using forward slashes)
+	// that the pattern is relative to.
+	base string
+	// The pattern split into path segments (where "**" matches any number of
+	// segments).
+	segments []string
+	// Whether or not the pattern only matches directories (i.e., it ends in /).
+	dirOnly bool
+	// Whether or not the pattern re-includes files (i.e., it starts with !).
+	negate bool
+}
+
+// Parse a pattern using .gitignore syntax.
+// Returns nil (and no error) for blank lines and comments.
+func parseIgnoreRule(pattern string, base string) (*ignoreRule, error) {
+	// Trailing spaces are ignored unless they're escaped
+	if !strings.HasSuffix(pattern, `\ `) {
+		pattern = strings.TrimRight(pattern, " \t\r")
+	}
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return nil, nil
+	}
+
+	r := &ignoreRule{base: base}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, `\!`) || strings.HasPrefix(pattern, `\#`) {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	// Patterns that contain a slash are relative to the base directory, other
+	// patterns match at any depth.
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return nil, errors.New("empty pattern")
+	}
+	r.segments = strings.Split(pattern, "/")
+	if !anchored {
+		r.segments = append([]string{"**"}, r.segments...)
+	}
+	for _, s := range r.segments {
+		if _, err := path.Match(s, ""); err != nil {
+			return nil, errors.Errorf("invalid pattern: %q", pattern)
+		}
+	}
+	return r, nil
+}
+
+// Check whether the rule matches the path (which is relative to the codex
+// directory and uses forward slashes).
+func (r *ignoreRule) matches(relpath string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(relpath, r.base+"/") {
+			return false
+		}
+		relpath = relpath[len(r.base)+1:]
+	}
+	return matchSegments(r.segments, strings.Split(relpath, "/"))
+}
+
+func matchSegments(pattern []string, parts []string) bool {
+	if len(pattern) == 0 {
+		return len(parts) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(pattern[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+		return false
+	}
+	return matchSegments(pattern[1:], parts[1:])
+}
+
+// fileFilter decides which files are uploaded as part of a codex based on the
+// ignore files (.pbignore and, optionally, .gitignore) in the codex directory
+// and the upload.include/upload.exclude globs.
+type fileFilter struct {
+	ignoreFiles []string
+	// The directories (relative to the codex directory) whose ignore files
+	// were read (whether or not they exist)
+	ignoreDirs []string
+	// Rules from ignore files (in the order they should be applied, i.e.,
+	// rules from deeper directories come later and take precedence).
+	ignored  []*ignoreRule
+	excluded []*ignoreRule
+	included []*ignoreRule
+}
+
+func newFileFilter(config *Config) (*fileFilter, error) {
+	f := &fileFilter{ignoreFiles: []string{IgnoreFileName}}
+	if config.Upload.UseGitignore {
+		f.ignoreFiles = append(f.ignoreFiles, gitignoreFileName)
+	}
+
+	var err error
+	f.excluded, err = parseGlobs(config.Upload.Exclude)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upload.exclude")
+	}
+	f.included, err = parseGlobs(config.Upload.Include)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid upload.include")
+	}
+	return f, nil
+}
+
+func parseGlobs(globs []string) ([]*ignoreRule, error) {
+	var rules []*ignoreRule
+	for _, glob := range globs {
+		if strings.HasPrefix(glob, "!") {
+			return nil, errors.Errorf("negated patterns aren't supported: %q", glob)
+		}
+		r, err := parseIgnoreRule(glob, "")
+		if err != nil {
+			return nil, err
+		}
+		if r != nil {
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// Read the ignore files in a directory (relpath is the path of the directory
+// relative to the codex directory).
+func (f *fileFilter) loadIgnoreFiles(dir string, relpath string) error {
+	f.ignoreDirs = append(f.ignoreDirs, relpath)
+	base := filepath.ToSlash(relpath)
+	if base == "." {
+		base = ""
+	}
+	for _, name := range f.ignoreFiles {
+		filename := filepath.Join(dir, name)
+		file, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to read ignore file (%s)", filename)
+		}
+		scanner := bufio.NewScanner(file)
+		for lineNo := 1; scanner.Scan(); lineNo++ {
+			r, err := parseIgnoreRule(scanner.Text(), base)
+			if err != nil {
+				_ = file.Close()
+				return errors.Wrapf(err, "%s:%d", filename, lineNo)
+			}
+			if r != nil {
+				f.ignored = append(f.ignored, r)
+			}
+		}
+		err = scanner.Err()
+		_ = file.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to read ignore file (%s)", filename)
+		}
+	}
+	return nil
+}
+
+// Check whether a file (or directory) should be skipped. The path is relative
+// to the codex directory.
+func (f *fileFilter) isExcluded(relpath string, isDir bool) bool {
+	relpath = filepath.ToSlash(relpath)
+
+	// As in git, the last matching pattern wins
+	ignored := false
+	for _, r := range f.ignored {
+		if r.matches(relpath, isDir) {
+			ignored = !r.negate
+		}
+	}
+	if ignored {
+		return true
+	}
+
+	for _, r := range f.excluded {
+		if r.matches(relpath, isDir) {
+			return true
+		}
+	}
+
+	// Directories are always traversed so that the files inside them can be
+	// included, but if any include globs are given, only files that match one of
+	// them (or that are inside a directory that matches one) are uploaded.
+	if isDir || len(f.included) == 0 {
+		return false
+	}
+	for p := relpath; p != "."; p = path.Dir(p) {
+		for _, r := range f.included {
+			if r.matches(p, p != relpath) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package codex
 import (
 	"context"
 	"github.com/pathbird/pbauthor/internal/auth"
+	"github.com/pathbird/pbauthor/internal/config"
 	"github.com/pathbird/pbauthor/internal/course"
 	"github.com/pathbird/pbauthor/internal/graphql"
 	"github.com/pkg/errors"
@@ -11,8 +12,33 @@ import (
 	"path/filepath"
 )
 
+type InitOptions struct {
+	// The ID of the course to upload the codex to (if empty, the user is prompted
+	// to select one).
+	Course string
+	// The ID of the codex category to upload the codex to (if empty, the user is
+	// prompted to select one).
+	CodexCategory string
+}
+
 // Initialize a new codex config file
-func InitConfig(dirname string) (*Config, error) {
+// Any options that aren't specified default to the default_course and
+// default_codex_category settings.
+func InitConfig(dirname string, opts *InitOptions) (*Config, error) {
+	if opts == nil {
+		opts = &InitOptions{}
+	}
+	userConfig, err := config.GetUserConfig()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Course == "" {
+		opts.Course = userConfig.DefaultCourse
+	}
+	if opts.CodexCategory == "" {
+		opts.CodexCategory = userConfig.DefaultCodexCategory
+	}
+
 	// Look for a codex file before initializing
 	files, err := ioutil.ReadDir(dirname)
 	if err != nil {
@@ -36,6 +62,23 @@ func InitConfig(dirname string) (*Config, error) {
 		configFile: configFile,
 	}
 
+	if opts.CodexCategory != "" {
+		conf.Upload.CodexCategory = opts.CodexCategory
+	} else {
+		cat, err := promptCodexCategory(opts.Course)
+		if err != nil {
+			return nil, err
+		}
+		conf.Upload.CodexCategory = cat.ID
+	}
+
+	if err := conf.Save(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func promptCodexCategory(courseID string) (*graphql.CodexCategory, error) {
 	// TODO: shouldn't create a new client here, but oh well
 	authn, err := auth.GetAuth()
 	if err != nil {
@@ -47,22 +90,25 @@ func InitConfig(dirname string) (*Config, error) {
 		return nil, err
 	}
 
-	cour, err := course.PromptCourse(courses)
-	if err != nil {
-		return nil, err
-	}
-
-	cat, err := course.PromptCodexCategory(cour.CodexCategories)
-	if err != nil {
-		return nil, err
+	var cour *graphql.Course
+	if courseID != "" {
+		for i := range courses {
+			if courses[i].Course.ID == courseID {
+				cour = &courses[i].Course
+				break
+			}
+		}
+		if cour == nil {
+			return nil, errors.Errorf("course (id: %s) could not be found (or you aren't an owner)", courseID)
+		}
+	} else {
+		cour, err = course.PromptCourse(courses)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	conf.Upload.CodexCategory = cat.ID
-
-	if err := conf.Save(); err != nil {
-		return nil, err
-	}
-	return conf, nil
+	return course.PromptCodexCategory(cour.CodexCategories)
 }
 
 func isCodexSourceFile(file os.FileInfo) bool {
@@ -0,0 +1,39 @@
+package codex
+
+import (
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// MemoryBytes returns the requested amount of memory in bytes (or 0 if no
+// amount was requested).
+func (k *KernelConfig) MemoryBytes() (int64, error) {
+	return parseMemory(k.Memory)
+}
+
+// TimeoutDuration returns the requested cell timeout (or 0 if no timeout was
+// requested).
+func (k *KernelConfig) TimeoutDuration() (time.Duration, error) {
+	if k.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(k.Timeout)
+	if err != nil || d <= 0 {
+		return 0, errors.Errorf("invalid timeout (expected a duration like 10m): %q", k.Timeout)
+	}
+	return d, nil
+}
+
+// Parse an amount of memory with an optional (decimal or binary) unit
+// (e.g., "512M", "4Gi", or "1073741824").
+func parseMemory(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	bytes, err := config.ParseByteSize(s)
+	if err != nil {
+		return 0, errors.Errorf("invalid amount of memory (expected something like 512Mi or 4Gi): %q", s)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,219 @@
+package codex
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/progress"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ManifestFileName is the name of the file (next to codex.toml) that records
+// the contents of the files from the last successful upload, so that unchanged
+// files don't have to be uploaded again.
+const ManifestFileName = ".pbmanifest.json"
+
+type manifest struct {
+	// The files from the last successful upload of each codex (keyed by codex
+	// ID and then by the slash-separated file name)
+	Codexes map[string]map[string]manifestFile `json:"codexes"`
+}
+
+type manifestFile struct {
+	Sha256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// The modification time (in nanoseconds since the Unix epoch) when the
+	// file was hashed (so that unchanged files don't have to be hashed again)
+	ModTime int64 `json:"modTime"`
+}
+
+func loadManifest(dir string) (*manifest, error) {
+	m := &manifest{Codexes: make(map[string]map[string]manifestFile)}
+	filename := filepath.Join(dir, ManifestFileName)
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read codex manifest (%s)", filename)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse codex manifest (%s)", filename)
+	}
+	if m.Codexes == nil {
+		m.Codexes = make(map[string]map[string]manifestFile)
+	}
+	return m, nil
+}
+
+func (m *manifest) save(dir string) error {
+	filename := filepath.Join(dir, ManifestFileName)
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal codex manifest")
+	}
+	if err := ioutil.WriteFile(filename, data, 0666); err != nil {
+		return errors.Wrapf(err, "failed to save codex manifest (%s)", filename)
+	}
+	return nil
+}
+
+// Remove the files of the codices that the config doesn't refer to anymore
+// (e.g., codices that were replaced by a new codex).
+func (m *manifest) prune(config *Config) {
+	keep := map[string]bool{config.Upload.CodexId: true}
+	for _, env := range config.Env {
+		keep[env.Upload.CodexId] = true
+	}
+	for codexId := range m.Codexes {
+		if codexId == "" || !keep[codexId] {
+			delete(m.Codexes, codexId)
+		}
+	}
+}
+
+// Hash the codex files, reusing the hashes from the manifest for files that
+// haven't been modified since they were hashed.
+func (m *manifest) hashFiles(files []api.FileRef) (map[string]manifestFile, error) {
+	hashed := make(map[string]manifestFile, len(files))
+	for _, f := range files {
+		name := filepath.ToSlash(f.Name)
+		stat, err := os.Stat(f.FsPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat codex file: %s", f.Name)
+		}
+		entry := manifestFile{Size: stat.Size(), ModTime: stat.ModTime().UnixNano()}
+		for _, files := range m.Codexes {
+			if cached, ok := files[name]; ok && cached.Size == entry.Size && cached.ModTime == entry.ModTime {
+				entry.Sha256 = cached.Sha256
+				break
+			}
+		}
+		if entry.Sha256 == "" {
+			entry.Sha256, err = hashFile(f.FsPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		hashed[name] = entry
+	}
+	return hashed, nil
+}
+
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", errors.Wrapf(err, "couldn't open file (%s)", filename)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "failed to hash file (%s)", filename)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Returns true if some of the files might be omitted from the upload (i.e., if
+// the codex was uploaded before).
+func (m *manifest) canOmitFiles(req *api.UploadCodexRequest, full bool) bool {
+	return !full && req.CodexId != "" && len(m.Codexes[req.CodexId]) > 0
+}
+
+// Add the manifest to the upload request and, if the codex was uploaded
+// before, omit the files that the API already has from the tar archive (if
+// client is nil, nothing is omitted).
+// Returns the hashed files (which should be saved to the manifest once the
+// upload succeeds).
+func prepareIncrementalUpload(
+	client *api.Client,
+	m *manifest,
+	req *api.UploadCodexRequest,
+	full bool,
+) (map[string]manifestFile, error) {
+	hashed, err := m.hashFiles(req.Files)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(hashed))
+	for name := range hashed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	req.Manifest = make([]api.ManifestEntry, len(names))
+	for i, name := range names {
+		req.Manifest[i] = api.ManifestEntry{
+			Name:   name,
+			Sha256: hashed[name].Sha256,
+			Size:   hashed[name].Size,
+		}
+	}
+
+	if !m.canOmitFiles(req, full) {
+		return hashed, nil
+	}
+	if client == nil {
+		log.Debug("not checking for unchanged files (no api client)")
+		return hashed, nil
+	}
+	codexFile, err := req.CodexFile()
+	if err != nil {
+		return nil, err
+	}
+	previous := m.Codexes[req.CodexId]
+
+	// Ask the API which of the blobs from the previous upload it still has
+	previousBlobs := make(map[string]bool)
+	for _, f := range previous {
+		previousBlobs[f.Sha256] = true
+	}
+	var candidates []string
+	seen := make(map[string]bool)
+	for _, e := range req.Manifest {
+		// The notebook is always sent (outside of the tar archive)
+		if e.Name == filepath.ToSlash(codexFile.Name) || !previousBlobs[e.Sha256] || seen[e.Sha256] {
+			continue
+		}
+		seen[e.Sha256] = true
+		candidates = append(candidates, e.Sha256)
+	}
+	if len(candidates) == 0 {
+		return hashed, nil
+	}
+	res, err := client.CheckCodexBlobs(&api.CheckCodexBlobsRequest{
+		CodexId: req.CodexId,
+		Blobs:   candidates,
+	})
+	if err != nil {
+		log.WithError(err).Warn("failed to check for unchanged files, uploading all files")
+		return hashed, nil
+	}
+	existing := make(map[string]bool, len(res.Existing))
+	for _, blob := range res.Existing {
+		existing[blob] = true
+	}
+
+	var omitted int
+	var omittedSize int64
+	for i, e := range req.Manifest {
+		if seen[e.Sha256] && existing[e.Sha256] {
+			req.Manifest[i].Omitted = true
+			omitted++
+			omittedSize += e.Size
+		}
+	}
+	if omitted > 0 {
+		log.Infof(
+			"skipping %d unchanged files (%s) that were already uploaded",
+			omitted,
+			progress.FormatBytes(omittedSize),
+		)
+	}
+	return hashed, nil
+}
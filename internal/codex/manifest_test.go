@@ -0,0 +1,101 @@
+package codex
+
+import (
+	"encoding/json"
+	"github.com/pathbird/pbauthor/internal/api"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrepareIncrementalUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []api.FileRef
+	for name, content := range map[string]string{
+		"notebook.ipynb": "{}",
+		"unchanged.csv":  "a,b,c",
+		"changed.csv":    "1,2,3",
+		"expired.csv":    "x,y,z",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, api.FileRef{Name: name, FsPath: path})
+	}
+
+	m := &manifest{Codexes: map[string]map[string]manifestFile{"c1": {}}}
+	for _, name := range []string{"notebook.ipynb", "unchanged.csv", "expired.csv"} {
+		sha, err := hashFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Codexes["c1"][name] = manifestFile{Sha256: sha}
+	}
+	m.Codexes["c1"]["changed.csv"] = manifestFile{Sha256: "old"}
+	expired := m.Codexes["c1"]["expired.csv"].Sha256
+
+	// The API has every blob except for expired.csv
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req api.CheckCodexBlobsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		var existing []string
+		for _, blob := range req.Blobs {
+			if blob != expired {
+				existing = append(existing, blob)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&api.CheckCodexBlobsResponse{Existing: existing})
+	}))
+	defer server.Close()
+
+	req := &api.UploadCodexRequest{CodexId: "c1", Files: files}
+	hashed, err := prepareIncrementalUpload(api.NewForHost(server.URL, "token"), m, req, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashed) != 4 || len(req.Manifest) != 4 {
+		t.Fatalf("expected all files to be hashed")
+	}
+	for _, e := range req.Manifest {
+		if e.Omitted != (e.Name == "unchanged.csv") {
+			t.Errorf("unexpected omitted value for %s: %v", e.Name, e.Omitted)
+		}
+	}
+
+	// Nothing is omitted for full uploads
+	req = &api.UploadCodexRequest{CodexId: "c1", Files: files}
+	if _, err := prepareIncrementalUpload(api.NewForHost(server.URL, "token"), m, req, true); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range req.Manifest {
+		if e.Omitted {
+			t.Errorf("unexpected omitted file: %s", e.Name)
+		}
+	}
+}
+
+func TestManifestPrune(t *testing.T) {
+	m := &manifest{Codexes: map[string]map[string]manifestFile{
+		"base": {}, "staging": {}, "replaced": {},
+	}}
+	config := &Config{
+		Upload: UploadConfig{CodexId: "base"},
+		Env:    map[string]EnvConfig{"staging": {Upload: UploadConfig{CodexId: "staging"}}},
+	}
+	m.prune(config)
+	if len(m.Codexes) != 2 || m.Codexes["base"] == nil || m.Codexes["staging"] == nil {
+		t.Errorf("expected only the codices in the config to be kept, got: %v", m.Codexes)
+	}
+}
@@ -0,0 +1,188 @@
+package codex
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pathbird/pbauthor/internal/progress"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Where the upload limits came from (see ResolveUploadLimits).
+const (
+	LimitsFromApi    = "advertised by the API"
+	LimitsFromConfig = "pbauthor defaults"
+)
+
+// ResolveUploadLimits gets the upload limits that are advertised by the API,
+// falling back to the limits from the user config (see `pbauthor config`) if
+// the API doesn't advertise any (or if client is nil).
+// It also returns where the limits came from.
+func ResolveUploadLimits(client *api.Client) (*api.UploadLimits, string, error) {
+	if client != nil {
+		limits, err := client.GetUploadLimits()
+		if err == nil {
+			return limits, LimitsFromApi, nil
+		}
+		log.WithError(err).Debug("failed to get upload limits from the api, using the defaults")
+	}
+
+	userConfig, err := config.GetUserConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	maxFiles, maxFileSize, maxPayloadSize := userConfig.GetUploadLimits()
+	return &api.UploadLimits{
+		MaxFiles:       maxFiles,
+		MaxFileSize:    maxFileSize,
+		MaxPayloadSize: maxPayloadSize,
+	}, LimitsFromConfig, nil
+}
+
+// PreflightReport compares an upload to the upload limits.
+type PreflightReport struct {
+	Limits api.UploadLimits
+	// Where the limits came from (e.g., LimitsFromApi)
+	LimitsSource string
+	// All of the codex files (largest first)
+	Files []api.PlannedFile
+	// The size of the request body (zero if unknown)
+	PayloadSize int64
+	// The limits that are exceeded (if any)
+	Problems []string
+	// The limits that couldn't be checked (e.g., the maximum payload size of a
+	// compressed upload, whose size isn't known before it's sent)
+	Unchecked []string
+
+	// The files that aren't sent since they haven't changed (see
+	// ManifestFileName)
+	omitted map[string]bool
+}
+
+// Exceeded returns true if any of the limits are exceeded.
+func (r *PreflightReport) Exceeded() bool {
+	return len(r.Problems) > 0
+}
+
+// LargestFiles returns (up to) the n largest files.
+func (r *PreflightReport) LargestFiles(n int) []api.PlannedFile {
+	if n > len(r.Files) {
+		n = len(r.Files)
+	}
+	return r.Files[:n]
+}
+
+// IsTooLarge returns true if the file exceeds the maximum file size (and is
+// going to be sent).
+func (r *PreflightReport) IsTooLarge(f api.PlannedFile) bool {
+	return r.Limits.MaxFileSize > 0 && f.Size > r.Limits.MaxFileSize && !r.omitted[f.Name]
+}
+
+// UploadLimitsError is returned by UploadCodex if the codex exceeds the upload
+// limits (so that nothing is uploaded).
+type UploadLimitsError struct {
+	Report *PreflightReport
+}
+
+func (e *UploadLimitsError) Error() string {
+	return fmt.Sprintf(
+		"codex exceeds the upload limits (%s): %s",
+		e.Report.LimitsSource,
+		strings.Join(e.Report.Problems, "; "),
+	)
+}
+
+var _ error = (*UploadLimitsError)(nil)
+
+// Check the upload against the upload limits without reading any of the files
+// (see preflightUpload).
+func checkUploadLimits(
+	req *api.UploadCodexRequest,
+	limits *api.UploadLimits,
+	limitsSource string,
+) (*PreflightReport, error) {
+	payloadSize, err := api.UploadCodexPayloadSize(req)
+	if err != nil {
+		return nil, err
+	}
+	return preflightUpload(req, payloadSize, limits, limitsSource)
+}
+
+// Check the number and sizes of the files and the size of the request body
+// (unless it's zero, i.e., unknown, see PreflightReport.Unchecked) against the
+// upload limits. The files that are omitted
+// from the upload (see api.ManifestEntry) count towards the number of files,
+// but not towards the file size limit.
+func preflightUpload(
+	req *api.UploadCodexRequest,
+	payloadSize int64,
+	limits *api.UploadLimits,
+	limitsSource string,
+) (*PreflightReport, error) {
+	r := &PreflightReport{
+		Limits:       *limits,
+		LimitsSource: limitsSource,
+		PayloadSize:  payloadSize,
+		omitted:      make(map[string]bool),
+	}
+	for _, e := range req.Manifest {
+		if e.Omitted {
+			r.omitted[e.Name] = true
+		}
+	}
+	for _, f := range req.Files {
+		stat, err := os.Stat(f.FsPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat codex file: %s", f.Name)
+		}
+		r.Files = append(r.Files, api.PlannedFile{Name: filepath.ToSlash(f.Name), Size: stat.Size()})
+	}
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Size > r.Files[j].Size
+	})
+
+	if limits.MaxFiles > 0 && len(r.Files) > limits.MaxFiles {
+		r.Problems = append(r.Problems, fmt.Sprintf(
+			"too many files: %d (limit: %d)",
+			len(r.Files),
+			limits.MaxFiles,
+		))
+	}
+	var tooLarge int
+	for _, f := range r.Files {
+		if r.IsTooLarge(f) {
+			tooLarge++
+		}
+	}
+	if tooLarge > 0 {
+		files := "files are"
+		if tooLarge == 1 {
+			files = "file is"
+		}
+		r.Problems = append(r.Problems, fmt.Sprintf(
+			"%d %s larger than the maximum file size (%s)",
+			tooLarge,
+			files,
+			progress.FormatBytes(limits.MaxFileSize),
+		))
+	}
+	if limits.MaxPayloadSize > 0 && payloadSize == 0 {
+		r.Unchecked = append(r.Unchecked, fmt.Sprintf(
+			"the upload size limit (%s) wasn't checked since the size of the compressed upload isn't known until it's sent",
+			progress.FormatBytes(limits.MaxPayloadSize),
+		))
+	}
+	if limits.MaxPayloadSize > 0 && payloadSize > limits.MaxPayloadSize {
+		r.Problems = append(r.Problems, fmt.Sprintf(
+			"upload is too large: %s (limit: %s)",
+			progress.FormatBytes(payloadSize),
+			progress.FormatBytes(limits.MaxPayloadSize),
+		))
+	}
+	return r, nil
+}
@@ -0,0 +1,106 @@
+package codex
+
+import (
+	"context"
+	"github.com/pathbird/pbauthor/internal/graphql"
+	"github.com/pathbird/pbauthor/internal/graphql/transport"
+	"github.com/pkg/errors"
+)
+
+// UploadTarget describes where a codex is going to be uploaded (so that the
+// author can confirm that it's the right place).
+type UploadTarget struct {
+	CodexCategoryId string
+	// The course and codex category (nil if the codex category couldn't be
+	// found among the courses that the user owns).
+	Course        *graphql.Course
+	CodexCategory *graphql.CodexCategory
+
+	// The ID of the codex that will be replaced (empty if a new codex will be
+	// created).
+	CodexId string
+	// The name of the codex that will be replaced (empty if it couldn't be
+	// found).
+	CodexName string
+}
+
+// IsReplace returns true if the upload will replace an existing codex.
+func (t *UploadTarget) IsReplace() bool {
+	return t.CodexId != ""
+}
+
+// ResolveUploadTarget looks up the names of the course, codex category, and
+// existing codex (if any) that the config refers to.
+func ResolveUploadTarget(
+	ctx context.Context,
+	client *graphql.Client,
+	config *Config,
+) (*UploadTarget, error) {
+	target := &UploadTarget{
+		CodexCategoryId: config.Upload.CodexCategory,
+		CodexId:         config.Upload.CodexId,
+	}
+	var err error
+	target.Course, target.CodexCategory, err = LookupCodexCategory(ctx, client, target.CodexCategoryId)
+	if err != nil {
+		return nil, err
+	}
+	if target.CodexId != "" {
+		target.CodexName, err = queryCodexName(ctx, client, target.CodexId)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return target, nil
+}
+
+// LookupCodexCategory finds a codex category (and the course that it belongs
+// to) among the courses that the user owns. If the codex category can't be
+// found, nil is returned for both.
+func LookupCodexCategory(
+	ctx context.Context,
+	client *graphql.Client,
+	categoryId string,
+) (*graphql.Course, *graphql.CodexCategory, error) {
+	courses, err := client.QueryCourses(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range courses {
+		course := &courses[i].Course
+		for j := range course.CodexCategories {
+			if course.CodexCategories[j].ID == categoryId {
+				return course, &course.CodexCategories[j], nil
+			}
+		}
+	}
+	return nil, nil, nil
+}
+
+const codexNameQuery = `
+query pbauthor_CodexName($id: ID!) {
+	node(id: $id) { ... on CodexMetadata {
+		id
+		name
+	}}
+}
+`
+
+// Get the name of a codex (or the empty string if it doesn't exist).
+func queryCodexName(ctx context.Context, client *graphql.Client, codexId string) (string, error) {
+	req := transport.NewRequest(codexNameQuery)
+	req.Var("id", codexId)
+	var res struct {
+		Node *struct {
+			ID   string
+			Name string
+		}
+	}
+	if err := client.Run(ctx, req, &res); err != nil {
+		return "", errors.Wrap(err, "failed to look up codex")
+	}
+	if res.Node == nil || res.Node.ID == "" {
+		return "", nil
+	}
+	return res.Node.Name, nil
+}
@@ -1,51 +1,128 @@
 package codex
 
 import (
+	"context"
 	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/graphql"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type UploadCodexOptions struct {
 	// The codex directory
 	Dir string
+	// The environment to upload to (see EnvConfig), if any
+	Env string
+
+	// If set, Confirm is called with the (resolved) upload target before
+	// anything is uploaded, and the upload is aborted if it returns false.
+	Confirm func(target *UploadTarget) bool
+	// The GraphQL client that's used to resolve the upload target (required if
+	// Confirm is set).
+	GraphQL *graphql.Client
+	// If set, called periodically with the progress of the upload.
+	Progress api.ProgressFunc
+	// Whether or not to upload the codex in chunks (so that an interrupted
+	// upload can be resumed).
+	Resumable bool
+	// Whether or not to upload all the files (even the ones that haven't
+	// changed since the last upload, see ManifestFileName).
+	Full bool
+	// If set, overrides upload.compression from the codex config.
+	Compression string
 }
 
+// ErrUploadAborted is returned by UploadCodex if the upload wasn't confirmed.
+var ErrUploadAborted = errors.New("upload aborted")
+
 func UploadCodex(
 	client *api.Client,
 	opts *UploadCodexOptions,
 ) (*api.UploadCodexResponse, *api.CodexParseFailedError, error) {
-	config, err := GetOrInitCodexConfig(opts.Dir)
+	baseConfig, err := GetOrInitCodexConfig(opts.Dir)
 	if err != nil {
 		return nil, nil, err
 	}
+	config, err := baseConfig.ForEnv(opts.Env)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := opts.applyOverrides(config); err != nil {
+		return nil, nil, err
+	}
 
-	files, err := getCodexFiles(config, opts.Dir)
+	req, err := buildUploadRequest(config, opts.Dir)
 	if err != nil {
 		return nil, nil, err
 	}
-	log.Debugf("got %d codex files", len(files))
 
-	// TODO:
-	// 		We should request a confirmation before doing the upload.
-	//		This will help make sure the author is aware of what course
-	//		they're uploading to (e.g., in case they try to re-upload
-	//		an old codex and intend to upload it to a new course but
-	//		but the config still points to the old course).
+	// Make sure the author is aware of what course they're uploading to (e.g.,
+	// in case they try to re-upload an old codex and intend to upload it to a
+	// new course but the config still points to the old course).
+	if opts.Confirm != nil {
+		target, err := ResolveUploadTarget(context.Background(), opts.GraphQL, config)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !opts.Confirm(target) {
+			return nil, nil, ErrUploadAborted
+		}
+	}
 
-	req := &api.UploadCodexRequest{
-		CodexCategoryId: config.Upload.CodexCategory,
-		Files:           files,
-		CodexId:         config.Upload.CodexId,
-		KernelOptions: api.KernelOptions{
-			SystemPackages: config.Kernel.SystemPackages,
-		},
+	// Check the limits before sending anything (rather than finding out when
+	// the API rejects the upload). This is done before hashing the files
+	// (which can take a while) and, if the codex was uploaded before, again
+	// once we know which files don't have to be sent.
+	limits, limitsSource, err := ResolveUploadLimits(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	report, err := checkUploadLimits(req, limits, limitsSource)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, err := loadManifest(opts.Dir)
+	if err != nil {
+		log.WithError(err).Warn("ignoring invalid codex manifest")
+		m = &manifest{Codexes: make(map[string]map[string]manifestFile)}
+	}
+	if report.Exceeded() && !m.canOmitFiles(req, opts.Full) {
+		return nil, nil, &UploadLimitsError{Report: report}
 	}
 
-	res, parseErr, err := client.UploadCodex(req)
+	hashed, err := prepareIncrementalUpload(client, m, req, opts.Full)
+	if err != nil {
+		return nil, nil, err
+	}
+	if report.Exceeded() {
+		report, err = checkUploadLimits(req, limits, limitsSource)
+		if err != nil {
+			return nil, nil, err
+		}
+		if report.Exceeded() {
+			return nil, nil, &UploadLimitsError{Report: report}
+		}
+	}
+	for _, unchecked := range report.Unchecked {
+		log.Info(unchecked)
+	}
+
+	req.Progress = opts.Progress
+	var res *api.UploadCodexResponse
+	var parseErr *api.CodexParseFailedError
+	if opts.Resumable {
+		sessions, sessionsErr := newFileUploadSessionStore()
+		if sessionsErr != nil {
+			return nil, nil, sessionsErr
+		}
+		res, parseErr, err = client.UploadCodexResumable(req, sessions)
+	} else {
+		res, parseErr, err = client.UploadCodex(req)
+	}
 	if parseErr != nil {
 		return nil, parseErr, nil
 	}
@@ -53,38 +130,191 @@ func UploadCodex(
 		return nil, nil, err
 	}
 
-	config.Upload.CodexId = res.CodexId
-	if err := config.Save(); err != nil {
+	baseConfig.SetCodexId(opts.Env, res.CodexId)
+	if err := baseConfig.Save(); err != nil {
 		return nil, nil, errors.Wrap(
 			err,
 			"codex upload succeeded, but failed to save codex config file",
 		)
 	}
+	m.Codexes[res.CodexId] = hashed
+	m.prune(baseConfig)
+	if err := m.save(opts.Dir); err != nil {
+		// This only means that the next upload will include all the files
+		log.WithError(err).Warn("failed to save codex manifest")
+	}
 
 	return res, nil, nil
 }
 
-const maxFiles = 100
+// UploadPlan describes what UploadCodex would send (see PlanUpload).
+type UploadPlan struct {
+	// The effective config (i.e., with the environment's overrides applied)
+	Config  *Config
+	Request *api.UploadCodexRequest
+	*api.UploadCodexPlan
+	// The result of checking the upload limits
+	Preflight *PreflightReport
+}
+
+// PlanUpload resolves the codex config and files exactly like UploadCodex, but
+// doesn't upload anything (or create the codex config file if it's missing).
+// If client is set, it's used to get the upload limits from the API and to
+// check which files haven't changed since the last upload (otherwise the
+// default limits are used and all files are included).
+func PlanUpload(client *api.Client, opts *UploadCodexOptions) (*UploadPlan, error) {
+	config, err := LoadConfig(opts.Dir, opts.Env)
+	if err != nil {
+		return nil, err
+	}
+	if err := opts.applyOverrides(config); err != nil {
+		return nil, err
+	}
+
+	req, err := buildUploadRequest(config, opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// Omit the unchanged files (like UploadCodex does)
+	m, err := loadManifest(opts.Dir)
+	if err != nil {
+		log.WithError(err).Warn("ignoring invalid codex manifest")
+		m = &manifest{Codexes: make(map[string]map[string]manifestFile)}
+	}
+	if _, err := prepareIncrementalUpload(client, m, req, opts.Full); err != nil {
+		return nil, err
+	}
+	plan, err := api.PlanUploadCodex(req)
+	if err != nil {
+		return nil, err
+	}
+	limits, limitsSource, err := ResolveUploadLimits(client)
+	if err != nil {
+		return nil, err
+	}
+	report, err := preflightUpload(req, plan.TotalSize, limits, limitsSource)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadPlan{Config: config, Request: req, UploadCodexPlan: plan, Preflight: report}, nil
+}
+
+// LoadConfig reads the codex config from the codex directory and applies the
+// overrides for the environment (if any). Unlike UploadCodex, it doesn't create
+// the config file if it's missing.
+func LoadConfig(dir string, env string) (*Config, error) {
+	configFile := filepath.Join(dir, ConfigFileName)
+	if _, err := os.Stat(configFile); os.IsNotExist(err) {
+		return nil, errors.Errorf(
+			"codex config file (%s) does not exist (run `pbauthor codex init` to create it)",
+			configFile,
+		)
+	}
+	baseConfig := &Config{}
+	if err := baseConfig.UnmarshalFromFile(configFile); err != nil {
+		return nil, err
+	}
+	return baseConfig.ForEnv(env)
+}
+
+// Apply the options that override the (effective) codex config.
+func (opts *UploadCodexOptions) applyOverrides(config *Config) error {
+	if opts.Compression != "" {
+		if err := checkCompression(opts.Compression); err != nil {
+			return err
+		}
+		config.Upload.Compression = opts.Compression
+	}
+	return nil
+}
+
+// Normalize the name of a file referenced by the config (e.g.,
+// "./requirements.txt") so that it matches the name of the file in the upload.
+func cleanCodexFileName(name string) string {
+	if name == "" {
+		return ""
+	}
+	return filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+}
+
+func buildUploadRequest(config *Config, dir string) (*api.UploadCodexRequest, error) {
+	files, err := getCodexFiles(config, dir)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("got %d codex files", len(files))
+
+	memory, err := config.Kernel.MemoryBytes()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := config.Kernel.TimeoutDuration()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.UploadCodexRequest{
+		CodexCategoryId: config.Upload.CodexCategory,
+		Files:           files,
+		CodexId:         config.Upload.CodexId,
+		Compression:     config.Upload.Compression,
+		ZeroModTimes:    config.Upload.ZeroMtimes,
+		KernelOptions: api.KernelOptions{
+			SystemPackages:   config.Kernel.SystemPackages,
+			PythonVersion:    config.Kernel.PythonVersion,
+			PipPackages:      config.Kernel.PipPackages,
+			RequirementsFile: cleanCodexFileName(config.Kernel.RequirementsFile),
+			EnvironmentFile:  cleanCodexFileName(config.Kernel.EnvironmentFile),
+			EnvVars:          config.Kernel.EnvVars,
+			Cpu:              config.Kernel.Cpu,
+			MemoryBytes:      memory,
+			TimeoutSeconds:   int64(timeout / time.Second),
+		},
+	}, nil
+}
 
 // Get all the files associated with the codex.
-// Recursively walks the filesystem starting at `dir`.
-func getCodexFiles(_ *Config, dir string) ([]api.FileRef, error) {
+// Recursively walks the filesystem starting at `dir` (skipping any files that
+// are ignored by a .pbignore file or the upload.include/upload.exclude globs).
+func getCodexFiles(config *Config, dir string) ([]api.FileRef, error) {
+	files, _, err := walkCodexFiles(config, dir)
+	return files, err
+}
+
+// Like getCodexFiles, but also returns the filter (which records the
+// directories whose ignore files were read).
+func walkCodexFiles(config *Config, dir string) ([]api.FileRef, *fileFilter, error) {
+	filter, err := newFileFilter(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var files []api.FileRef
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		isHidden := strings.HasPrefix(info.Name(), ".")
 
+		relpath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return errors.Wrapf(err, "couldn't determine relative file path: %s", path)
+		}
+
 		if info.IsDir() {
-			// Don't recurse into hidden directories
-			if isHidden {
+			if relpath == "." {
+				return filter.loadIgnoreFiles(path, relpath)
+			}
+			// Don't recurse into hidden or ignored directories
+			if isHidden || filter.isExcluded(relpath, true) {
+				log.Debugf("skipping directory: %s", relpath)
 				return filepath.SkipDir
 			}
-			// For non-hidden directories, we'll still recurse into all the files
-			// but we don't need to do anything with the directory itself.
-			return nil
+			// For other directories, we'll still recurse into all the files
+			// but we don't need to do anything with the directory itself
+			// (except for reading its ignore files).
+			return filter.loadIgnoreFiles(path, relpath)
 		}
 
 		// Don't upload any hidden files
@@ -97,14 +327,11 @@ func getCodexFiles(_ *Config, dir string) ([]api.FileRef, error) {
 			return nil
 		}
 
-		relpath, err := filepath.Rel(dir, path)
-		if err != nil {
-			return errors.Wrapf(err, "couldn't determine relative file path: %s", path)
+		if filter.isExcluded(relpath, false) {
+			log.Debugf("skipping file: %s", relpath)
+			return nil
 		}
 
-		if len(files) > maxFiles {
-			return errors.Errorf("too many codex files (exceeds limit: %d)", maxFiles)
-		}
 		files = append(files, api.FileRef{
 			Name:   relpath,
 			FsPath: path,
@@ -114,8 +341,8 @@ func getCodexFiles(_ *Config, dir string) ([]api.FileRef, error) {
 	})
 
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to build codex file list")
+		return nil, nil, errors.Wrap(err, "unable to build codex file list")
 	}
 
-	return files, nil
+	return files, filter, nil
 }
@@ -0,0 +1,84 @@
+package codex
+
+import (
+	"encoding/json"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pathbird/pbauthor/internal/config"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const uploadSessionsFileName = "upload-sessions.json"
+
+// fileUploadSessionStore stores resumable upload sessions in
+// ~/.pathbird/upload-sessions.json.
+type fileUploadSessionStore struct {
+	file string
+}
+
+var _ api.UploadSessionStore = (*fileUploadSessionStore)(nil)
+
+func newFileUploadSessionStore() (*fileUploadSessionStore, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return &fileUploadSessionStore{file: filepath.Join(dir, uploadSessionsFileName)}, nil
+}
+
+func (s *fileUploadSessionStore) load() (map[string]*api.UploadSession, error) {
+	sessions := make(map[string]*api.UploadSession)
+	data, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read upload sessions file (%s)", s.file)
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse upload sessions file (%s)", s.file)
+	}
+	return sessions, nil
+}
+
+func (s *fileUploadSessionStore) save(sessions map[string]*api.UploadSession) error {
+	data, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal upload sessions")
+	}
+	if err := ioutil.WriteFile(s.file, data, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write upload sessions file (%s)", s.file)
+	}
+	return nil
+}
+
+func (s *fileUploadSessionStore) Get(key string) (*api.UploadSession, error) {
+	sessions, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	return sessions[key], nil
+}
+
+func (s *fileUploadSessionStore) Put(key string, session *api.UploadSession) error {
+	sessions, err := s.load()
+	if err != nil {
+		return err
+	}
+	sessions[key] = session
+	return s.save(sessions)
+}
+
+func (s *fileUploadSessionStore) Delete(key string) error {
+	sessions, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := sessions[key]; !ok {
+		return nil
+	}
+	delete(sessions, key)
+	return s.save(sessions)
+}
@@ -1,9 +1,11 @@
 package codex
 
 import (
+	"github.com/pathbird/pbauthor/internal/api"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -35,3 +37,150 @@ func TestGetCodexFiles(t *testing.T) {
 		t.Errorf("unexpected file path: %s", f.FsPath)
 	}
 }
+
+func TestGetCodexFilesIgnored(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		".pbignore":                       "__pycache__/\n*.log\n!keep.log\n/scratch\n",
+		".gitignore":                      "venv/\n",
+		"notebook.ipynb":                  "{}",
+		"debug.log":                       "",
+		"keep.log":                        "",
+		"__pycache__/foo.pyc":             "",
+		"lib/__pycache__/bar.pyc":         "",
+		"lib/util.py":                     "",
+		"lib/.pbignore":                   "generated.py\n",
+		"lib/generated.py":                "",
+		"scratch/data.csv":                "",
+		"lib/scratch/data.csv":            "",
+		"venv/bin/python":                 "",
+		"data/large.csv":                  "",
+		"data/small.csv":                  "",
+		"images/plot.png":                 "",
+		"images/.ipynb_checkpoints/x.png": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{Upload: UploadConfig{
+		Exclude:      []string{"data/large.csv"},
+		Include:      []string{"*.ipynb", "*.log", "lib", "data/*.csv", "venv"},
+		UseGitignore: true,
+	}}
+	refs, err := getCodexFiles(config, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, ref := range refs {
+		names = append(names, filepath.ToSlash(ref.Name))
+	}
+	expected := []string{
+		"data/small.csv",
+		"keep.log",
+		"lib/scratch/data.csv",
+		"lib/util.py",
+		"notebook.ipynb",
+	}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("unexpected files: %v", names)
+	}
+}
+
+func TestPreflightUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files []api.FileRef
+	for name, size := range map[string]int{"notebook.ipynb": 10, "small.csv": 100, "large.csv": 5000} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, api.FileRef{Name: name, FsPath: path})
+	}
+	req := &api.UploadCodexRequest{Files: files}
+
+	report, err := preflightUpload(req, 6000, &api.UploadLimits{
+		MaxFiles:       3,
+		MaxFileSize:    1000,
+		MaxPayloadSize: 10000,
+	}, LimitsFromConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "1 file is larger") {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+	if largest := report.LargestFiles(1); len(largest) != 1 || largest[0].Name != "large.csv" {
+		t.Errorf("unexpected largest files: %v", largest)
+	}
+
+	report, err = preflightUpload(req, 6000, &api.UploadLimits{MaxFiles: 2, MaxPayloadSize: 5000}, LimitsFromApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 2 {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+
+	// Files that aren't sent can't be too large
+	req.Manifest = []api.ManifestEntry{{Name: "large.csv", Size: 5000, Omitted: true}}
+	report, err = preflightUpload(req, 1000, &api.UploadLimits{MaxFiles: 3, MaxFileSize: 1000}, LimitsFromApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Exceeded() {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+
+	// The size of compressed uploads isn't known in advance
+	req = &api.UploadCodexRequest{Files: files, Compression: api.CompressionGzip}
+	report, err = checkUploadLimits(req, &api.UploadLimits{MaxPayloadSize: 10}, LimitsFromApi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Exceeded() || len(report.Unchecked) != 1 {
+		t.Errorf("expected the payload size to be unchecked, got: %v (unchecked: %v)", report.Problems, report.Unchecked)
+	}
+}
+
+func TestBuildUploadRequestCleansKernelFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"codex.ipynb", "requirements.txt"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config := &Config{Kernel: KernelConfig{RequirementsFile: "./requirements.txt"}}
+	req, err := buildUploadRequest(config, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.KernelOptions.RequirementsFile != "requirements.txt" {
+		t.Errorf("unexpected requirements file: %q", req.KernelOptions.RequirementsFile)
+	}
+	if req.KernelOptions.EnvironmentFile != "" {
+		t.Errorf("unexpected environment file: %q", req.KernelOptions.EnvironmentFile)
+	}
+}
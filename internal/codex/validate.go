@@ -0,0 +1,570 @@
+package codex
+
+import (
+	"fmt"
+	"github.com/pathbird/pbauthor/internal/api"
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a problem found in a codex config file.
+type Diagnostic struct {
+	// The (1-based) line and column of the problem (0 if unknown).
+	Line   int
+	Column int
+	// The (dotted) key that the problem relates to (if any).
+	Key     string
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return d.Message
+	}
+	return fmt.Sprintf("%d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// ValidationError is returned when a codex config file is invalid.
+type ValidationError struct {
+	File        string
+	Diagnostics []Diagnostic
+}
+
+func (e *ValidationError) Error() string {
+	name := e.File
+	if name == "" {
+		name = ConfigFileName
+	}
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("invalid codex config (%d issues):", len(e.Diagnostics)))
+	for _, d := range e.Diagnostics {
+		sb.WriteString(fmt.Sprintf("\n  %s:%s", name, d.String()))
+	}
+	return sb.String()
+}
+
+var _ error = (*ValidationError)(nil)
+
+// ValidateConfig checks a codex config file for syntax errors, unknown keys,
+// values of the wrong type, and invalid values.
+// The returned diagnostics are sorted by position.
+func ValidateConfig(data []byte) []Diagnostic {
+	return ValidateConfigInDir(data, "")
+}
+
+// ValidateConfigInDir is like ValidateConfig, but also checks that the files
+// referenced by the config (e.g., kernel.requirements_file) exist in the codex
+// directory and aren't excluded from the upload.
+func ValidateConfigInDir(data []byte, dir string) []Diagnostic {
+	tree, err := toml.LoadBytes(data)
+	if err != nil {
+		return []Diagnostic{parseErrorDiagnostic(err)}
+	}
+
+	v := &configValidator{dir: dir}
+	v.checkTable(tree, reflect.TypeOf(Config{}), nil)
+	if len(v.diagnostics) == 0 {
+		// Only check the values if the structure is valid (otherwise we'd
+		// report confusing errors, e.g., missing keys that were just misspelled).
+		var c Config
+		normalizeFloats(tree, reflect.TypeOf(c))
+		if err := tree.Unmarshal(&c); err != nil {
+			return []Diagnostic{{Message: err.Error()}}
+		}
+		c.tree = tree
+		v.checkValues(tree, &c)
+	}
+
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		a, b := v.diagnostics[i], v.diagnostics[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.diagnostics
+}
+
+type configValidator struct {
+	// The codex directory (if empty, file references aren't checked)
+	dir         string
+	diagnostics []Diagnostic
+	// The referenced files that were already reported (e.g., because they
+	// don't exist)
+	invalidFiles map[string]bool
+}
+
+func (v *configValidator) addf(pos toml.Position, key []string, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{
+		Line:    pos.Line,
+		Column:  pos.Col,
+		Key:     strings.Join(key, "."),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// Check that all of the keys in the table correspond to fields of the struct
+// and that their values have the right types.
+func (v *configValidator) checkTable(tree *toml.Tree, t reflect.Type, path []string) {
+	fields := tomlFields(t)
+	for _, key := range tree.Keys() {
+		keyPath := append(append([]string{}, path...), key)
+		value := tree.GetPath([]string{key})
+		pos := positionOf(tree, key, value)
+
+		field, ok := fields[key]
+		if !ok {
+			msg := fmt.Sprintf("unknown key %s", strings.Join(keyPath, "."))
+			if suggestion := closestKey(key, fields); suggestion != "" {
+				msg += fmt.Sprintf(" (did you mean %s?)", suggestion)
+			}
+			v.addf(pos, keyPath, "%s", msg)
+			continue
+		}
+		v.checkValue(pos, value, field.Type, keyPath)
+	}
+}
+
+func (v *configValidator) checkValue(pos toml.Position, value interface{}, t reflect.Type, path []string) {
+	key := strings.Join(path, ".")
+	switch t.Kind() {
+	case reflect.Struct:
+		sub, ok := value.(*toml.Tree)
+		if !ok {
+			v.addf(pos, path, "%s must be a table (got %s)", key, describeTomlValue(value))
+			return
+		}
+		v.checkTable(sub, t, path)
+	case reflect.Map:
+		sub, ok := value.(*toml.Tree)
+		if !ok {
+			v.addf(pos, path, "%s must be a table (got %s)", key, describeTomlValue(value))
+			return
+		}
+		for _, k := range sub.Keys() {
+			subValue := sub.GetPath([]string{k})
+			v.checkValue(positionOf(sub, k, subValue), subValue, t.Elem(), append(path[:len(path):len(path)], k))
+		}
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			v.addf(pos, path, "%s must be an array (got %s)", key, describeTomlValue(value))
+			return
+		}
+		for i, item := range items {
+			v.checkValue(pos, item, t.Elem(), append(path[:len(path):len(path)], strconv.Itoa(i)))
+		}
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			v.addf(pos, path, "%s must be a string (got %s)", key, describeTomlValue(value))
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			v.addf(pos, path, "%s must be a boolean (got %s)", key, describeTomlValue(value))
+		}
+	case reflect.Int, reflect.Int64:
+		if _, ok := value.(int64); !ok {
+			v.addf(pos, path, "%s must be an integer (got %s)", key, describeTomlValue(value))
+		}
+	case reflect.Float64:
+		switch value.(type) {
+		case float64, int64:
+		default:
+			v.addf(pos, path, "%s must be a number (got %s)", key, describeTomlValue(value))
+		}
+	}
+}
+
+// Check the values of the config (e.g., that required keys are set).
+func (v *configValidator) checkValues(tree *toml.Tree, c *Config) {
+	v.checkUpload(tree, &c.Upload, []string{"upload"})
+	v.checkKernel(tree, &c.Kernel, []string{"kernel"})
+	for _, name := range c.EnvNames() {
+		env := c.Env[name]
+		v.checkUpload(tree, &env.Upload, []string{"env", name, "upload"})
+		v.checkKernel(tree, &env.Kernel, []string{"env", name, "kernel"})
+	}
+	v.checkCodexCategory(tree, c)
+	if v.dir != "" {
+		v.checkKernelFilesUploaded(tree, c)
+	}
+}
+
+func (v *configValidator) checkUpload(tree *toml.Tree, u *UploadConfig, path []string) {
+	check := func(key string, globs []string) {
+		keyPath := append(path[:len(path):len(path)], key)
+		if _, err := parseGlobs(globs); err != nil {
+			v.addf(positionOfPath(tree, keyPath), keyPath, "%s: %s", strings.Join(keyPath, "."), err)
+		}
+	}
+	check("include", u.Include)
+	check("exclude", u.Exclude)
+
+	if err := checkCompression(u.Compression); err != nil {
+		key := append(path[:len(path):len(path)], "compression")
+		v.addf(positionOfPath(tree, key), key, "%s: %s", strings.Join(key, "."), err)
+	}
+}
+
+func checkCompression(compression string) error {
+	if compression == "" {
+		return nil
+	}
+	for _, c := range api.Compressions {
+		if compression == c {
+			return nil
+		}
+	}
+	return errors.Errorf(
+		"unsupported compression %q (expected one of: %s)",
+		compression,
+		strings.Join(api.Compressions, ", "),
+	)
+}
+
+var pythonVersionRegex = regexp.MustCompile(`^\d+(\.\d+){0,2}$`)
+
+func (v *configValidator) checkKernel(tree *toml.Tree, k *KernelConfig, path []string) {
+	keyPath := func(key string) []string {
+		return append(path[:len(path):len(path)], key)
+	}
+
+	if k.PythonVersion != "" && !pythonVersionRegex.MatchString(k.PythonVersion) {
+		key := keyPath("python_version")
+		v.addf(
+			positionOfPath(tree, key),
+			key,
+			"%s must be a version number like \"3.9\" (got %q)",
+			strings.Join(key, "."),
+			k.PythonVersion,
+		)
+	}
+
+	for _, pkg := range k.PipPackages {
+		key := keyPath("pip_packages")
+		pkg = strings.TrimSpace(pkg)
+		if pkg == "" {
+			v.addf(positionOfPath(tree, key), key, "%s must not contain empty package names", strings.Join(key, "."))
+		} else if strings.HasPrefix(pkg, "-") {
+			v.addf(
+				positionOfPath(tree, key),
+				key,
+				"%s must only contain package names (got %q, use requirements_file for pip options)",
+				strings.Join(key, "."),
+				pkg,
+			)
+		}
+	}
+
+	v.checkCodexFile(tree, keyPath("requirements_file"), k.RequirementsFile)
+	v.checkCodexFile(tree, keyPath("environment_file"), k.EnvironmentFile)
+
+	for name := range k.EnvVars {
+		if !envVarNameRegex.MatchString(name) {
+			key := append(keyPath("env_vars"), name)
+			v.addf(positionOfPath(tree, key), key, "invalid environment variable name: %q", name)
+		}
+	}
+	// An explicit 0 would be dropped from the upload request (i.e., the
+	// default would be used), so it's rejected rather than silently ignored
+	if key := keyPath("cpu"); k.Cpu < 0 || (k.Cpu == 0 && tree.HasPath(key)) {
+		v.addf(positionOfPath(tree, key), key, "%s must be positive (got %v)", strings.Join(key, "."), k.Cpu)
+	}
+	if _, err := k.MemoryBytes(); err != nil {
+		key := keyPath("memory")
+		v.addf(positionOfPath(tree, key), key, "%s: %s", strings.Join(key, "."), err)
+	}
+	if _, err := k.TimeoutDuration(); err != nil {
+		key := keyPath("timeout")
+		v.addf(positionOfPath(tree, key), key, "%s: %s", strings.Join(key, "."), err)
+	}
+}
+
+var envVarNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Check that a file referenced by the config is part of the codex (i.e., that it
+// exists inside the codex directory and will be uploaded).
+func (v *configValidator) checkCodexFile(tree *toml.Tree, key []string, name string) {
+	if name == "" {
+		return
+	}
+	pos := positionOfPath(tree, key)
+	keyName := strings.Join(key, ".")
+	invalid := func(format string, args ...interface{}) {
+		v.addf(pos, key, format, args...)
+		if v.invalidFiles == nil {
+			v.invalidFiles = make(map[string]bool)
+		}
+		v.invalidFiles[name] = true
+	}
+
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		invalid("%s must be a path inside the codex directory (got %q)", keyName, name)
+		return
+	}
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			invalid("%s must not be a hidden file (hidden files aren't uploaded): %q", keyName, name)
+			return
+		}
+	}
+	if v.dir == "" {
+		return
+	}
+
+	stat, err := os.Stat(filepath.Join(v.dir, clean))
+	if os.IsNotExist(err) {
+		invalid("%s does not exist in the codex directory: %q", keyName, name)
+	} else if err != nil {
+		invalid("%s could not be read: %s", keyName, err)
+	} else if !stat.Mode().IsRegular() {
+		invalid("%s must be a file: %q", keyName, name)
+	}
+}
+
+// Check that the files referenced by the kernel config aren't excluded from
+// the upload (by the ignore files or upload.include/exclude). The files are
+// checked against the effective config of each environment they're used in.
+func (v *configValidator) checkKernelFilesUploaded(tree *toml.Tree, c *Config) {
+	reported := make(map[string]bool)
+	for _, env := range append([]string{""}, c.EnvNames()...) {
+		effective, err := c.ForEnv(env)
+		if err != nil {
+			// The base config can't be uploaded (see checkCodexCategory)
+			continue
+		}
+		path := []string{"kernel"}
+		if env != "" {
+			path = []string{"env", env, "kernel"}
+		}
+		files, _, err := walkCodexFiles(effective, v.dir)
+		if err != nil {
+			// Invalid globs are reported by checkUpload
+			continue
+		}
+		uploaded := make(map[string]bool)
+		for _, f := range files {
+			uploaded[filepath.ToSlash(f.Name)] = true
+		}
+
+		for _, k := range []struct {
+			key  string
+			name string
+		}{
+			{"requirements_file", effective.Kernel.RequirementsFile},
+			{"environment_file", effective.Kernel.EnvironmentFile},
+		} {
+			if k.name == "" || v.invalidFiles[k.name] {
+				continue
+			}
+			if uploaded[cleanCodexFileName(k.name)] {
+				continue
+			}
+			key := append(path[:len(path):len(path)], k.key)
+			if !tree.HasPath(key) {
+				// Inherited from the base config
+				key = []string{"kernel", k.key}
+			}
+			keyName := strings.Join(key, ".")
+			if reported[keyName] {
+				continue
+			}
+			reported[keyName] = true
+			where := ""
+			if env != "" {
+				where = fmt.Sprintf(" for environment %s", env)
+			}
+			v.addf(
+				positionOfPath(tree, key),
+				key,
+				"%s is excluded from the upload%s (by %s or upload.include/exclude): %q",
+				keyName,
+				where,
+				IgnoreFileName,
+				k.name,
+			)
+		}
+	}
+}
+
+func (v *configValidator) checkCodexCategory(tree *toml.Tree, c *Config) {
+	if c.Upload.CodexCategory != "" {
+		return
+	}
+	if len(c.Env) == 0 {
+		v.addf(
+			positionOfPath(tree, []string{"upload", "codex_category"}),
+			[]string{"upload", "codex_category"},
+			"upload.codex_category must be specified",
+		)
+		return
+	}
+	// Without a default category, every environment needs to specify one
+	for _, name := range c.EnvNames() {
+		if c.Env[name].Upload.CodexCategory == "" {
+			key := []string{"env", name, "upload", "codex_category"}
+			v.addf(
+				positionOfPath(tree, key),
+				key,
+				"%s must be specified (since upload.codex_category isn't set)",
+				strings.Join(key, "."),
+			)
+		}
+	}
+}
+
+// Get the position of a key (falling back to the position of the table that
+// contains it if the key doesn't exist).
+func positionOfPath(tree *toml.Tree, path []string) toml.Position {
+	for i := len(path); i > 0; i-- {
+		value := tree.GetPath(path[:i])
+		if value == nil {
+			continue
+		}
+		if sub, ok := value.(*toml.Tree); ok {
+			return sub.Position()
+		}
+		return tree.GetPositionPath(path[:i])
+	}
+	return toml.Position{Line: 1, Col: 1}
+}
+
+func positionOf(tree *toml.Tree, key string, value interface{}) toml.Position {
+	if sub, ok := value.(*toml.Tree); ok {
+		return sub.Position()
+	}
+	return tree.GetPositionPath([]string{key})
+}
+
+// Map TOML keys to struct fields.
+func tomlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("toml")
+		if tag == "" || tag == "-" {
+			continue
+		}
+		fields[strings.Split(tag, ",")[0]] = f
+	}
+	return fields
+}
+
+// TOML distinguishes between integers and floats, but go-toml can't unmarshal
+// integers into float fields (e.g., `cpu = 2`), so convert them first.
+func normalizeFloats(tree *toml.Tree, t reflect.Type) {
+	fields := tomlFields(t)
+	for _, key := range tree.Keys() {
+		field, ok := fields[key]
+		if !ok {
+			continue
+		}
+		value := tree.GetPath([]string{key})
+		switch field.Type.Kind() {
+		case reflect.Float64:
+			if i, ok := value.(int64); ok {
+				pos := tree.GetPositionPath([]string{key})
+				tree.SetPath([]string{key}, float64(i))
+				tree.SetPositionPath([]string{key}, pos)
+			}
+		case reflect.Struct:
+			if sub, ok := value.(*toml.Tree); ok {
+				normalizeFloats(sub, field.Type)
+			}
+		case reflect.Map:
+			sub, ok := value.(*toml.Tree)
+			if !ok || field.Type.Elem().Kind() != reflect.Struct {
+				continue
+			}
+			for _, k := range sub.Keys() {
+				if elem, ok := sub.GetPath([]string{k}).(*toml.Tree); ok {
+					normalizeFloats(elem, field.Type.Elem())
+				}
+			}
+		}
+	}
+}
+
+func describeTomlValue(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case []interface{}:
+		return "array"
+	case *toml.Tree:
+		return "table"
+	case []*toml.Tree:
+		return "array of tables"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+var tomlParseErrorRegex = regexp.MustCompile(`^\((\d+), (\d+)\): (.*)$`)
+
+// Convert a go-toml parse error (which looks like "(1, 2): message") into a
+// diagnostic.
+func parseErrorDiagnostic(err error) Diagnostic {
+	m := tomlParseErrorRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return Diagnostic{Message: err.Error()}
+	}
+	line, _ := strconv.Atoi(m[1])
+	col, _ := strconv.Atoi(m[2])
+	return Diagnostic{Line: line, Column: col, Message: m[3]}
+}
+
+// Find the known key that's closest to the (presumably misspelled) key.
+func closestKey(key string, fields map[string]reflect.StructField) string {
+	best, bestDist := "", 3 // don't suggest anything that's too different
+	for candidate := range fields {
+		if d := levenshtein(key, candidate); d < bestDist || (d == bestDist && candidate < best) {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
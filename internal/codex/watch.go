@@ -0,0 +1,158 @@
+package codex
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type WatchOptions struct {
+	// The codex directory
+	Dir string
+	// The environment (see EnvConfig), which determines which files are
+	// uploaded (e.g., via upload.exclude)
+	Env string
+	// How often to check the files for changes
+	PollInterval time.Duration
+	// How long to wait after the last change before calling onChange (so that,
+	// e.g., saving several files at once only results in one upload)
+	Debounce time.Duration
+}
+
+// The state of a file (used to detect changes).
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// Watch polls the codex directory for changes until the context is done.
+// Only the files that would be uploaded (see getCodexFiles) are watched, along
+// with the codex config and ignore files (since they determine which files are
+// uploaded). Once the changes have settled, onChange is called with the names
+// of the files that changed.
+//
+// Changes that are made while onChange is running (e.g., UploadCodex saving
+// the codex ID to the config) don't trigger another call.
+func Watch(ctx context.Context, opts *WatchOptions, onChange func(changed []string)) error {
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	return watch(ctx, opts, ticker.C, onChange)
+}
+
+// Check for changes whenever a tick is received (the ticks are also used as
+// the current time, so tests can control the time).
+func watch(
+	ctx context.Context,
+	opts *WatchOptions,
+	ticks <-chan time.Time,
+	onChange func(changed []string),
+) error {
+	baseline, err := snapshotCodexDir(opts.Dir, opts.Env)
+	if err != nil {
+		return err
+	}
+	previous := baseline
+	var lastChange time.Time
+
+	for {
+		var now time.Time
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now = <-ticks:
+		}
+
+		current, err := snapshotCodexDir(opts.Dir, opts.Env)
+		if err != nil {
+			// The directory might be in the middle of being changed (e.g., a
+			// file was deleted while we were reading it), so just try again
+			log.WithError(err).Debug("failed to check codex directory for changes")
+			continue
+		}
+		if len(diffSnapshots(previous, current)) > 0 {
+			lastChange = now
+		}
+		previous = current
+
+		changed := diffSnapshots(baseline, current)
+		if len(changed) == 0 || now.Sub(lastChange) < opts.Debounce {
+			continue
+		}
+		onChange(changed)
+
+		baseline, err = snapshotCodexDir(opts.Dir, opts.Env)
+		if err != nil {
+			return err
+		}
+		previous = baseline
+	}
+}
+
+func snapshotCodexDir(dir string, env string) (map[string]watchedFile, error) {
+	config, err := LoadConfig(dir, env)
+	if err != nil {
+		// Watch all of the files until the config is fixed (UploadCodex will
+		// report the problem)
+		log.WithError(err).Debug("failed to load codex config while watching")
+		config = &Config{}
+	}
+	files, filter, err := walkCodexFiles(config, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := make(map[string]watchedFile, len(files)+1)
+	add := func(name string, path string) error {
+		stat, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", path)
+		}
+		snapshot[filepath.ToSlash(name)] = watchedFile{size: stat.Size(), modTime: stat.ModTime()}
+		return nil
+	}
+
+	if err := add(ConfigFileName, filepath.Join(dir, ConfigFileName)); err != nil {
+		return nil, err
+	}
+	// The ignore files in every directory that was searched (including the
+	// ones that don't exist yet). The .gitignore files are watched even if
+	// they aren't used since upload.use_gitignore might be turned on.
+	for _, relpath := range filter.ignoreDirs {
+		for _, name := range []string{IgnoreFileName, gitignoreFileName} {
+			name = filepath.Join(relpath, name)
+			if err := add(name, filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, f := range files {
+		if err := add(f.Name, f.FsPath); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// Get the (sorted) names of the files that were added, removed, or modified.
+func diffSnapshots(before, after map[string]watchedFile) []string {
+	var changed []string
+	for name, a := range after {
+		if b, ok := before[name]; !ok || a.size != b.size || !a.modTime.Equal(b.modTime) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
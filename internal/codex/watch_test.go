@@ -0,0 +1,93 @@
+package codex
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name string, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(ConfigFileName, "[upload]\ncodex_category = \"cat1\"\nexclude = [\"*.log\"]\n")
+	write("notebook.ipynb", "{}")
+	write("data/a.csv", "a")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticks := make(chan time.Time)
+	changes := make(chan []string, 10)
+	done := make(chan error)
+	go func() {
+		done <- watch(ctx, &WatchOptions{Dir: dir, Debounce: 100 * time.Millisecond}, ticks, func(changed []string) {
+			// Changes made while handling a change are ignored
+			write(ConfigFileName, "[upload]\ncodex_category = \"cat1\"\ncodex_id = \"c1\"\nexclude = [\"*.log\"]\n")
+			changes <- changed
+		})
+	}()
+
+	// Since the ticks channel is unbuffered, each tick is only received once
+	// the previous tick has been handled
+	start := time.Now()
+	var last time.Duration
+	tick := func(elapsed time.Duration) {
+		last = elapsed
+		ticks <- start.Add(elapsed)
+	}
+	expectChanges := func(expected string) {
+		// Wait for the last tick to be handled (repeating it doesn't change
+		// anything)
+		tick(last)
+		select {
+		case changed := <-changes:
+			if strings.Join(changed, ",") != expected {
+				t.Errorf("expected changes %q, got: %v", expected, changed)
+			}
+		default:
+			if expected != "" {
+				t.Errorf("expected changes %q, got none", expected)
+			}
+		}
+	}
+
+	// The first tick is received after the initial snapshot
+	tick(0)
+	write("notebook.ipynb", `{"cells": []}`)
+	write("ignored.log", "ignored")
+	tick(10 * time.Millisecond)
+	write("data.csv", "a,b")
+	tick(50 * time.Millisecond)
+	// The changes haven't settled yet
+	tick(100 * time.Millisecond)
+	expectChanges("")
+	tick(2 * time.Hour)
+	expectChanges("data.csv,notebook.ipynb")
+
+	// Ignore files in subdirectories are watched too
+	write("data/.pbignore", "a.csv\n")
+	tick(3 * time.Hour)
+	tick(4 * time.Hour)
+	expectChanges("data/.pbignore,data/a.csv")
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -5,10 +5,39 @@ import (
 	"strings"
 )
 
+const DefaultApiHost = "https://pathbird.com"
+
 var PathbirdApiHost = (func() string {
 	value, set := os.LookupEnv("PATHBIRD_API_HOST")
 	if set {
 		return strings.TrimRight(value, "/")
 	}
-	return "https://pathbird.com"
+	return DefaultApiHost
+})()
+
+// ApiHostExplicit is true if the API host was explicitly requested (via the
+// --api-host flag or the PATHBIRD_API_HOST environment variable). Otherwise,
+// the host is determined by the active profile.
+var ApiHostExplicit = (func() bool {
+	_, set := os.LookupEnv("PATHBIRD_API_HOST")
+	return set
 })()
+
+// DefaultHost returns the API host to use when no host was explicitly requested
+// and the active profile isn't bound to a host (i.e., the api_host setting or
+// the built-in default).
+func DefaultHost() string {
+	c, err := GetUserConfig()
+	if err != nil || c.ApiHost == "" {
+		return DefaultApiHost
+	}
+	return strings.TrimRight(c.ApiHost, "/")
+}
+
+// ResolveApiHost applies the api_host setting (unless a host was explicitly
+// requested via --api-host or PATHBIRD_API_HOST).
+func ResolveApiHost() {
+	if !ApiHostExplicit {
+		PathbirdApiHost = DefaultHost()
+	}
+}
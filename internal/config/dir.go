@@ -0,0 +1,20 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"os/user"
+	"path"
+)
+
+// Dir returns the directory where pbauthor stores its state (~/.pathbird),
+// creating it if necessary.
+func Dir() (string, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return "", errors.Wrap(err, "unable to determine home directory")
+	}
+	dir := path.Join(currentUser.HomeDir, ".pathbird")
+	_ = os.MkdirAll(dir, 0700)
+	return dir, nil
+}
@@ -0,0 +1,12 @@
+package config
+
+import (
+	"os"
+)
+
+const ProfileEnvVar = "PATHBIRD_PROFILE"
+
+// Profile is the name of the profile requested via the --profile flag or the
+// PATHBIRD_PROFILE environment variable. If empty, the profile that was most
+// recently selected with `pbauthor auth profiles use` is used.
+var Profile = os.Getenv(ProfileEnvVar)
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var byteSizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMGT]i?)?B?$`)
+
+var byteSizeUnits = map[string]float64{
+	"":   1,
+	"K":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// ParseByteSize parses a number of bytes with an optional (decimal or binary)
+// unit (e.g., "512M", "4Gi", or "1073741824").
+func ParseByteSize(s string) (int64, error) {
+	m := byteSizeRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, errors.Errorf("invalid size (expected something like 512Mi or 4Gi): %q", s)
+	}
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid size: %q", s)
+	}
+	bytes := int64(n * byteSizeUnits[m[2]])
+	if bytes <= 0 {
+		return 0, errors.Errorf("invalid size (must be positive): %q", s)
+	}
+	return bytes, nil
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"github.com/pelletier/go-toml"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const UserConfigFileName = "config.toml"
+
+// UserConfig holds the user's global settings (stored in ~/.pathbird/config.toml).
+//
+// Every setting can also be overridden with an environment variable named after
+// the key (e.g., PATHBIRD_API_HOST for api_host). Command line flags take
+// precedence over both.
+type UserConfig struct {
+	// The default API host (used when the active profile isn't bound to a host).
+	ApiHost string `toml:"api_host,omitempty" builtin:"https://pathbird.com"`
+	// The credential store used to save API tokens
+	// (one of "file", "encrypted-file", or "keyring").
+	CredentialsStore string `toml:"credentials_store,omitempty" builtin:"file"`
+	// Whether or not to use colors in output (by default, colors are used when
+	// writing to a terminal).
+	Color *bool `toml:"color,omitempty" builtin:"(auto)"`
+	// Whether or not to check for new versions of pbauthor (default: true).
+	CheckForUpdates *bool `toml:"check_for_updates,omitempty" builtin:"true"`
+	// How long to wait for the kernel build after uploading a codex (e.g., "20m").
+	UploadWaitTimeout string `toml:"upload_wait_timeout,omitempty" builtin:"20m"`
+	// The ID of the course to select by default in `pbauthor codex init`.
+	DefaultCourse string `toml:"default_course,omitempty"`
+	// The ID of the codex category to use by default in `pbauthor codex init`.
+	DefaultCodexCategory string `toml:"default_codex_category,omitempty"`
+	// The upload limits that are checked before uploading a codex (if the API
+	// doesn't advertise its own limits).
+	UploadMaxFiles       string `toml:"upload_max_files,omitempty" builtin:"100"`
+	UploadMaxFileSize    string `toml:"upload_max_file_size,omitempty" builtin:"100Mi"`
+	UploadMaxPayloadSize string `toml:"upload_max_payload_size,omitempty" builtin:"500Mi"`
+}
+
+const DefaultUploadWaitTimeout = 20 * time.Minute
+
+const (
+	DefaultUploadMaxFiles       = 100
+	DefaultUploadMaxFileSize    = 100 << 20
+	DefaultUploadMaxPayloadSize = 500 << 20
+)
+
+// UserConfigKey describes a single setting in the user config file.
+type UserConfigKey struct {
+	// The name of the key in the config file (e.g., api_host)
+	Name string
+	// The name of the environment variable that overrides the key
+	Env string
+	// A description of the built-in default value
+	Default string
+
+	index int
+}
+
+var userConfigKeys = (func() []UserConfigKey {
+	t := reflect.TypeOf(UserConfig{})
+	var keys []UserConfigKey
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("toml"), ",")[0]
+		keys = append(keys, UserConfigKey{
+			Name:    name,
+			Env:     "PATHBIRD_" + strings.ToUpper(name),
+			Default: t.Field(i).Tag.Get("builtin"),
+			index:   i,
+		})
+	}
+	return keys
+})()
+
+// UserConfigKeys returns all of the known settings.
+func UserConfigKeys() []UserConfigKey {
+	return userConfigKeys
+}
+
+func lookupUserConfigKey(name string) (*UserConfigKey, error) {
+	for _, k := range userConfigKeys {
+		if k.Name == name {
+			return &k, nil
+		}
+	}
+	return nil, errors.Errorf("unknown config key: %s", name)
+}
+
+// Get returns the value of a setting (and whether or not it's set).
+func (c *UserConfig) Get(name string) (string, bool, error) {
+	key, err := lookupUserConfigKey(name)
+	if err != nil {
+		return "", false, err
+	}
+	field := reflect.ValueOf(c).Elem().Field(key.index)
+	switch {
+	case field.Kind() == reflect.String:
+		return field.String(), field.String() != "", nil
+	case isBoolPtr(field):
+		if field.IsNil() {
+			return "", false, nil
+		}
+		return strconv.FormatBool(field.Elem().Bool()), true, nil
+	default:
+		return "", false, errors.Errorf("unsupported type for config key %s: %s", name, field.Type())
+	}
+}
+
+// Set sets the value of a setting from its string representation.
+// Setting a value to the empty string unsets it.
+func (c *UserConfig) Set(name string, value string) error {
+	key, err := lookupUserConfigKey(name)
+	if err != nil {
+		return err
+	}
+	field := reflect.ValueOf(c).Elem().Field(key.index)
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case isBoolPtr(field):
+		if value == "" {
+			field.Set(reflect.Zero(field.Type()))
+			break
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.Errorf("invalid value for %s (expected true or false): %q", name, value)
+		}
+		field.Set(reflect.ValueOf(&b))
+	default:
+		return errors.Errorf("unsupported type for config key %s: %s", name, field.Type())
+	}
+	return c.validate()
+}
+
+// Whether or not the field is a *bool (i.e., an optional flag).
+func isBoolPtr(field reflect.Value) bool {
+	return field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Bool
+}
+
+func (c *UserConfig) validate() error {
+	switch c.CredentialsStore {
+	case "", "file", "encrypted-file", "keyring":
+	default:
+		return errors.Errorf(
+			"invalid value for credentials_store: %q (expected file, encrypted-file, or keyring)",
+			c.CredentialsStore,
+		)
+	}
+	if c.UploadWaitTimeout != "" {
+		if _, err := time.ParseDuration(c.UploadWaitTimeout); err != nil {
+			return errors.Errorf(
+				"invalid value for upload_wait_timeout (expected a duration like 20m): %q",
+				c.UploadWaitTimeout,
+			)
+		}
+	}
+	if c.UploadMaxFiles != "" {
+		if n, err := strconv.Atoi(c.UploadMaxFiles); err != nil || n <= 0 {
+			return errors.Errorf(
+				"invalid value for upload_max_files (expected a positive integer): %q",
+				c.UploadMaxFiles,
+			)
+		}
+	}
+	for name, value := range map[string]string{
+		"upload_max_file_size":    c.UploadMaxFileSize,
+		"upload_max_payload_size": c.UploadMaxPayloadSize,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := ParseByteSize(value); err != nil {
+			return errors.Errorf("invalid value for %s (expected a size like 100Mi): %q", name, value)
+		}
+	}
+	if c.ApiHost != "" && !strings.HasPrefix(c.ApiHost, "http://") && !strings.HasPrefix(c.ApiHost, "https://") {
+		return errors.Errorf("invalid value for api_host (expected an http(s) URL): %q", c.ApiHost)
+	}
+	return nil
+}
+
+// GetUploadWaitTimeout returns the parsed upload_wait_timeout (or the default).
+func (c *UserConfig) GetUploadWaitTimeout() time.Duration {
+	if c.UploadWaitTimeout == "" {
+		return DefaultUploadWaitTimeout
+	}
+	d, err := time.ParseDuration(c.UploadWaitTimeout)
+	if err != nil {
+		return DefaultUploadWaitTimeout
+	}
+	return d
+}
+
+// GetUploadLimits returns the parsed upload limits (or the defaults).
+func (c *UserConfig) GetUploadLimits() (maxFiles int, maxFileSize int64, maxPayloadSize int64) {
+	maxFiles, maxFileSize, maxPayloadSize =
+		DefaultUploadMaxFiles, DefaultUploadMaxFileSize, DefaultUploadMaxPayloadSize
+	if n, err := strconv.Atoi(c.UploadMaxFiles); err == nil && n > 0 {
+		maxFiles = n
+	}
+	if n, err := ParseByteSize(c.UploadMaxFileSize); err == nil {
+		maxFileSize = n
+	}
+	if n, err := ParseByteSize(c.UploadMaxPayloadSize); err == nil {
+		maxPayloadSize = n
+	}
+	return maxFiles, maxFileSize, maxPayloadSize
+}
+
+// GetCheckForUpdates returns whether or not to check for new versions.
+func (c *UserConfig) GetCheckForUpdates() bool {
+	return c.CheckForUpdates == nil || *c.CheckForUpdates
+}
+
+func UserConfigFile() (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, UserConfigFileName), nil
+}
+
+// LoadUserConfigFile reads the user config file (ignoring any environment
+// variable overrides). A missing file is treated the same as an empty one.
+func LoadUserConfigFile() (*UserConfig, error) {
+	file, err := UserConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	c := &UserConfig{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file (%s)", file)
+	}
+	if err := toml.Unmarshal(data, c); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse config file (%s)", file)
+	}
+	if err := c.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file (%s)", file)
+	}
+	return c, nil
+}
+
+// SaveUserConfigFile writes the user config file.
+func SaveUserConfigFile(c *UserConfig) error {
+	file, err := UserConfigFile()
+	if err != nil {
+		return err
+	}
+	data, err := toml.Marshal(c)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal config")
+	}
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write config file (%s)", file)
+	}
+	userConfigCache = nil
+	return nil
+}
+
+var userConfigCache = (*UserConfig)(nil)
+
+// GetUserConfig returns the effective user config (i.e., the config file with
+// environment variable overrides applied).
+func GetUserConfig() (*UserConfig, error) {
+	if userConfigCache != nil {
+		return userConfigCache, nil
+	}
+	c, err := LoadUserConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range userConfigKeys {
+		if value, set := os.LookupEnv(key.Env); set && value != "" {
+			if err := c.Set(key.Name, value); err != nil {
+				return nil, errors.Wrapf(err, "invalid value for %s", key.Env)
+			}
+		}
+	}
+	userConfigCache = c
+	return c, nil
+}
+
+// CredentialsStore returns the name of the credential store to use.
+func CredentialsStore() (string, error) {
+	c, err := GetUserConfig()
+	if err != nil {
+		return "", err
+	}
+	return c.CredentialsStore, nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestUserConfigSetGet(t *testing.T) {
+	c := &UserConfig{}
+
+	if err := c.Set("check_for_updates", "false"); err != nil {
+		t.Fatal(err)
+	}
+	if c.GetCheckForUpdates() {
+		t.Error("expected check_for_updates to be false")
+	}
+	value, set, err := c.Get("check_for_updates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set || value != "false" {
+		t.Errorf("unexpected value for check_for_updates: %q (set: %v)", value, set)
+	}
+
+	if err := c.Set("check_for_updates", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, set, _ := c.Get("check_for_updates"); set {
+		t.Error("expected check_for_updates to be unset")
+	}
+
+	if err := c.Set("upload_wait_timeout", "forever"); err == nil {
+		t.Error("expected an error for an invalid duration")
+	}
+	if err := c.Set("check_for_updates", "maybe"); err == nil {
+		t.Error("expected an error for an invalid bool")
+	}
+	if err := c.Set("not_a_key", "foo"); err == nil {
+		t.Error("expected an error for an unknown key")
+	}
+}
@@ -0,0 +1,78 @@
+package graphql
+
+import (
+	"context"
+	"github.com/pkg/errors"
+)
+
+type queryApiKeysRes struct {
+	Viewer struct {
+		User struct {
+			ID      string   `json:"id"`
+			ApiKeys []ApiKey `json:"apiKeys"`
+		} `json:"user"`
+	} `json:"viewer"`
+}
+
+// QueryApiKeys lists the (non-revoked) API keys that belong to the viewer.
+func (c *Client) QueryApiKeys(ctx context.Context) ([]ApiKey, error) {
+	var res queryApiKeysRes
+	err := c.runAndUnmarshall(ctx, &res)
+	if err != nil {
+		return nil, err
+	}
+	if res.Viewer.User.ID == "" {
+		return nil, errors.New("not authenticated")
+	}
+	return res.Viewer.User.ApiKeys, nil
+}
+
+type CreateApiKeyInput struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// Optional (if empty, the key never expires)
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+type createApiKeyRes struct {
+	CreateApiKey struct {
+		ApiKey ApiKey `json:"apiKey"`
+		// The secret token for the key (only returned when the key is created)
+		Token string `json:"token"`
+	} `json:"createApiKey" args:"input CreateApiKeyInput!"`
+}
+
+// CreateApiKey creates a new long-lived API key.
+// The returned token is the only time the secret is available.
+func (c *Client) CreateApiKey(ctx context.Context, input *CreateApiKeyInput) (*ApiKey, string, error) {
+	var res createApiKeyRes
+	err := c.mutateAndUnmarshall(ctx, &res, withVariable("input", input))
+	if err != nil {
+		return nil, "", err
+	}
+	if res.CreateApiKey.Token == "" {
+		return nil, "", errors.New("api didn't return a token for the new api key")
+	}
+	return &res.CreateApiKey.ApiKey, res.CreateApiKey.Token, nil
+}
+
+type revokeApiKeyRes struct {
+	RevokeApiKey struct {
+		ApiKey struct {
+			ID string `json:"id"`
+		} `json:"apiKey"`
+	} `json:"revokeApiKey" args:"id ID!"`
+}
+
+// RevokeApiKey revokes an API key so that it can no longer be used.
+func (c *Client) RevokeApiKey(ctx context.Context, id string) error {
+	var res revokeApiKeyRes
+	err := c.mutateAndUnmarshall(ctx, &res, withVariable("id", id))
+	if err != nil {
+		return err
+	}
+	if res.RevokeApiKey.ApiKey.ID == "" {
+		return errors.Errorf("api key (id: %s) could not be found", id)
+	}
+	return nil
+}
@@ -10,16 +10,29 @@ import (
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"reflect"
+	"sync"
 )
 
 type Client struct {
 	*transport.Client
+	// auth is guarded by mu (since it's replaced when reauthenticating, and
+	// clients are shared by concurrent uploads)
+	mu   sync.Mutex
 	auth *auth.Auth
+	// If set, rejected credentials are reported as ErrUnauthenticated
+	// (without asking the user to log in again)
+	noReauth bool
 }
 
 func NewClient(auth *auth.Auth) *Client {
+	// Use the host that the credentials were issued by (which is determined by
+	// the active profile) so that tokens are never sent to the wrong host.
+	host := config.PathbirdApiHost
+	if auth != nil && auth.Host != "" {
+		host = auth.Host
+	}
 	client := transport.NewClient(
-		fmt.Sprintf("%s/graphql", config.PathbirdApiHost),
+		fmt.Sprintf("%s/graphql", host),
 		transport.ImmediatelyCloseReqBody(),
 	)
 	client.Log = func(s string) {
@@ -31,9 +44,51 @@ func NewClient(auth *auth.Auth) *Client {
 	}
 }
 
+// ErrUnauthenticated is returned when the API rejects the client's credentials
+// (and the user couldn't log in again).
+var ErrUnauthenticated = errors.New("You are not logged in (try running `pbauthor auth login`)")
+
+// Run executes the request using the client's credentials.
+// If the credentials are rejected, the user is asked to log in again (when
+// running interactively) and the request is retried once.
 func (c *Client) Run(ctx context.Context, req *transport.Request, res interface{}) error {
-	if c.auth != nil && c.auth.ApiToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.auth.ApiToken))
+	rejected := c.getAuth()
+	err := c.run(ctx, rejected, req, res)
+	if err != transport.ErrUnauthorized {
+		return err
+	}
+	if c.noReauth {
+		return ErrUnauthenticated
+	}
+
+	log.Debug("graphql request was unauthorized, attempting to reauthenticate")
+	newAuth, reauthErr := auth.Reauthenticate(rejected.ApiToken)
+	if reauthErr != nil {
+		log.WithError(reauthErr).Debug("failed to reauthenticate")
+		return ErrUnauthenticated
+	}
+	c.mu.Lock()
+	c.auth = newAuth
+	c.mu.Unlock()
+	return c.run(ctx, newAuth, req, res)
+}
+
+// WithoutReauthentication makes the client return ErrUnauthenticated if the
+// credentials are rejected (e.g., when verifying a new token).
+func (c *Client) WithoutReauthentication() *Client {
+	c.noReauth = true
+	return c
+}
+
+func (c *Client) getAuth() *auth.Auth {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.auth
+}
+
+func (c *Client) run(ctx context.Context, a *auth.Auth, req *transport.Request, res interface{}) error {
+	if a != nil && a.ApiToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", a.ApiToken))
 	} else {
 		return errors.New("authorization not set")
 	}
@@ -62,7 +117,25 @@ func withVariable(name string, value interface{}) runOption {
 }
 
 func (c *Client) runAndUnmarshall(ctx context.Context, v interface{}, opts ...runOption) error {
-	queryString, err := graphql_reflect.BuildQuery(reflect.TypeOf(v))
+	return c.buildRunAndUnmarshall(ctx, v, nil, opts...)
+}
+
+func (c *Client) mutateAndUnmarshall(ctx context.Context, v interface{}, opts ...runOption) error {
+	return c.buildRunAndUnmarshall(
+		ctx,
+		v,
+		[]graphql_reflect.BuildQueryOpt{graphql_reflect.AsMutation()},
+		opts...,
+	)
+}
+
+func (c *Client) buildRunAndUnmarshall(
+	ctx context.Context,
+	v interface{},
+	buildOpts []graphql_reflect.BuildQueryOpt,
+	opts ...runOption,
+) error {
+	queryString, err := graphql_reflect.BuildQuery(reflect.TypeOf(v), buildOpts...)
 	if err != nil {
 		return err
 	}
@@ -16,6 +16,13 @@ func WithQueryName(name string) BuildQueryOpt {
 	}
 }
 
+// AsMutation builds a mutation (rather than a query).
+func AsMutation() BuildQueryOpt {
+	return func(builder *queryBuilder) {
+		builder.operation = Mutation
+	}
+}
+
 func BuildQuery(t reflect.Type, opts ...BuildQueryOpt) (string, error) {
 	qb := &queryBuilder{
 		operation: Query,
@@ -98,7 +105,17 @@ func (qb *queryBuilder) addFragment(t reflect.Type) error {
 
 		sb.WriteString(" ")
 		switch f.Type.Kind() {
-		case reflect.Struct, reflect.Array, reflect.Ptr, reflect.Slice:
+		case reflect.Array, reflect.Ptr, reflect.Slice:
+			// Lists of scalars (e.g., [String!]) don't have a selection set
+			if isScalarKind(f.Type.Elem().Kind()) {
+				sb.WriteString(jsonTag)
+				if err := qb.writeArgs(sb, &f); err != nil {
+					return err
+				}
+				continue
+			}
+			fallthrough
+		case reflect.Struct:
 			// Maybe TODO:
 			//		We don't currently support embedded structs. It's not a huge deal,
 			//		and it's not clear how to actually do that, but it would be nice to
@@ -113,16 +130,26 @@ func (qb *queryBuilder) addFragment(t reflect.Type) error {
 				return err
 			}
 			sb.WriteString(" }")
-		case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
-			sb.WriteString(jsonTag)
 		default:
-			return errors.Errorf("invalid struct field type: %s.%s", f.Type.Name(), f.Type.Kind())
+			if !isScalarKind(f.Type.Kind()) {
+				return errors.Errorf("invalid struct field type: %s.%s", f.Type.Name(), f.Type.Kind())
+			}
+			sb.WriteString(jsonTag)
 		}
 	}
 
 	return nil
 }
 
+func isScalarKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64, reflect.Int, reflect.Int64:
+		return true
+	default:
+		return false
+	}
+}
+
 func (qb *queryBuilder) writeArgs(sb *strings.Builder, field *reflect.StructField) error {
 	tag := field.Tag.Get("args")
 	if tag == "" {
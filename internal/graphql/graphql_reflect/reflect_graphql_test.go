@@ -60,3 +60,21 @@ func TestReflectGraphQLWithArgs(t *testing.T) {
 		t.Errorf("fragment does not match:\n\texpected: %s\n\tgot:      %s\n", expected, actual)
 	}
 }
+
+func TestReflectGraphQLMutationWithScalarList(t *testing.T) {
+	type CreateThing struct {
+		CreateThing struct {
+			ID   string   `json:"id"`
+			Tags []string `json:"tags"`
+		} `json:"createThing" args:"input CreateThingInput!"`
+	}
+	frag, err := BuildQuery(reflect.TypeOf(&CreateThing{}), AsMutation())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	const expected = "mutation($input: CreateThingInput!) { createThing(input: $input) { id tags } }"
+	actual := strings.TrimSpace(frag)
+	if actual != expected {
+		t.Errorf("fragment does not match:\n\texpected: %s\n\tgot:      %s\n", expected, actual)
+	}
+}
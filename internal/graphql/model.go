@@ -21,3 +21,12 @@ type User struct {
 	Name  string `json:"name"`
 	Email string `json:"email"`
 }
+
+type ApiKey struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"createdAt"`
+	ExpiresAt  string   `json:"expiresAt"`
+	LastUsedAt string   `json:"lastUsedAt"`
+}
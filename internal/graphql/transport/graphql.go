@@ -138,6 +138,9 @@ func (c *Client) runWithJSON(ctx context.Context, req *Request, resp interface{}
 		return errors.Wrap(err, "reading body")
 	}
 	c.logf("<< %s", buf.String())
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
 	if err := json.NewDecoder(&buf).Decode(&gr); err != nil {
 		if res.StatusCode != http.StatusOK {
 			return fmt.Errorf("graphql: server returned a non-200 status code: %v", res.StatusCode)
@@ -209,6 +212,9 @@ func (c *Client) runWithPostFields(ctx context.Context, req *Request, resp inter
 		return errors.Wrap(err, "reading body")
 	}
 	c.logf("<< %s", buf.String())
+	if res.StatusCode == http.StatusUnauthorized {
+		return ErrUnauthorized
+	}
 	if err := json.NewDecoder(&buf).Decode(&gr); err != nil {
 		if res.StatusCode != http.StatusOK {
 			return fmt.Errorf("graphql: server returned a non-200 status code: %v", res.StatusCode)
@@ -250,6 +256,10 @@ func ImmediatelyCloseReqBody() ClientOption {
 // modify the behaviour of the Client.
 type ClientOption func(*Client)
 
+// ErrUnauthorized is returned when the server rejects the request's
+// credentials.
+var ErrUnauthorized = errors.New("graphql: server returned 401 Unauthorized")
+
 type graphErr struct {
 	Message string
 }
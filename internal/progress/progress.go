@@ -0,0 +1,150 @@
+package progress
+
+import (
+	"fmt"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	barWidth = 30
+	// How often to redraw the progress bar (on terminals)
+	redrawInterval = 100 * time.Millisecond
+	// How often to log the progress (when not writing to a terminal)
+	logInterval = 5 * time.Second
+)
+
+// Reporter reports the progress of a long-running transfer (e.g., a codex
+// upload). It draws a progress bar when writing to a terminal and logs the
+// progress periodically otherwise.
+type Reporter struct {
+	label       string
+	out         io.Writer
+	interactive bool
+
+	mu         sync.Mutex
+	start      time.Time
+	lastReport time.Time
+	written    int64
+	total      int64
+	drawn      bool
+}
+
+// New creates a Reporter that writes to stderr.
+func New(label string) *Reporter {
+	return &Reporter{
+		label:       label,
+		out:         os.Stderr,
+		interactive: isatty.IsTerminal(os.Stderr.Fd()),
+	}
+}
+
+// Update records the number of bytes that have been written so far (out of the
+// given total). It's safe to call Update as often as necessary since the
+// output is throttled.
+func (r *Reporter) Update(written int64, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if r.start.IsZero() || written < r.written {
+		// Start over (e.g., if the transfer was retried)
+		r.start = now
+		r.lastReport = time.Time{}
+	}
+	r.written, r.total = written, total
+
+	interval := logInterval
+	if r.interactive {
+		interval = redrawInterval
+	}
+	if now.Sub(r.lastReport) < interval {
+		return
+	}
+	r.lastReport = now
+	r.report(now)
+}
+
+// Done reports the final progress (and finishes the progress bar).
+func (r *Reporter) Done() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.start.IsZero() {
+		return
+	}
+	if r.written > r.total {
+		r.total = r.written
+	}
+	r.report(time.Now())
+	if r.interactive && r.drawn {
+		_, _ = fmt.Fprintln(r.out)
+		r.drawn = false
+	}
+}
+
+func (r *Reporter) report(now time.Time) {
+	written, total := r.written, r.total
+	if written > total {
+		// The total is only an estimate
+		total = written
+	}
+
+	elapsed := now.Sub(r.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(written) / elapsed.Seconds()
+	}
+	eta := "--"
+	if rate > 0 && written < total {
+		remaining := time.Duration(float64(total-written) / rate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+	status := fmt.Sprintf(
+		"%s / %s  %s/s  ETA %s",
+		FormatBytes(written),
+		FormatBytes(total),
+		FormatBytes(int64(rate)),
+		eta,
+	)
+
+	if !r.interactive {
+		log.Infof("%s: %d%% (%s)", r.label, percent(written, total), status)
+		return
+	}
+
+	filled := 0
+	if total > 0 {
+		filled = int(float64(barWidth) * float64(written) / float64(total))
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled)
+	// Pad the line so that any leftovers from the previous (longer) line are
+	// overwritten
+	_, _ = fmt.Fprintf(r.out, "\r%s [%s] %3d%%  %-50s", r.label, bar, percent(written, total), status)
+	r.drawn = true
+}
+
+func percent(written, total int64) int {
+	if total <= 0 {
+		return 0
+	}
+	return int(100 * written / total)
+}
+
+// FormatBytes formats a number of bytes using binary units (e.g., "1.5 MiB").
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
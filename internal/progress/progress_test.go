@@ -0,0 +1,98 @@
+package progress
+
+import (
+	"bytes"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	for n, expected := range map[int64]string{
+		0:                  "0 B",
+		1023:               "1023 B",
+		1024:               "1.0 KiB",
+		1536:               "1.5 KiB",
+		1024*1024 - 1:      "1024.0 KiB",
+		1024 * 1024:        "1.0 MiB",
+		1024 * 1024 * 1024: "1.0 GiB",
+	} {
+		if actual := FormatBytes(n); actual != expected {
+			t.Errorf("expected %d to be formatted as %q, got %q", n, expected, actual)
+		}
+	}
+}
+
+func TestPercent(t *testing.T) {
+	if p := percent(10, 0); p != 0 {
+		t.Errorf("expected 0%% for an unknown total, got %d%%", p)
+	}
+	if p := percent(50, 200); p != 25 {
+		t.Errorf("expected 25%%, got %d%%", p)
+	}
+}
+
+func TestReporterRestart(t *testing.T) {
+	var out bytes.Buffer
+	r := &Reporter{label: "Uploading", out: &out, interactive: true}
+	r.Update(500, 1000)
+	start := time.Now().Add(-time.Minute)
+	r.start = start
+
+	// The transfer was retried
+	r.Update(100, 1000)
+	if !r.start.After(start) {
+		t.Error("expected the reporter to start over")
+	}
+	if r.written != 100 {
+		t.Errorf("expected 100 bytes to be written, got %d", r.written)
+	}
+}
+
+func TestReporterClampsToTotal(t *testing.T) {
+	var out bytes.Buffer
+	r := &Reporter{label: "Uploading", out: &out, interactive: true}
+	r.Update(2048, 1024)
+	line := out.String()
+	if !strings.Contains(line, "100%") || !strings.Contains(line, "2.0 KiB / 2.0 KiB") {
+		t.Errorf("expected the progress to be clamped to 100%%, got: %q", line)
+	}
+	if !strings.Contains(line, "["+strings.Repeat("=", barWidth)+"]") {
+		t.Errorf("expected a full progress bar, got: %q", line)
+	}
+
+	r.Done()
+	if r.total != 2048 {
+		t.Errorf("expected the total to be updated to 2048, got %d", r.total)
+	}
+}
+
+func TestReporterThrottlesLogs(t *testing.T) {
+	logger := log.StandardLogger()
+	defer func(out io.Writer, level log.Level) {
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+	}(logger.Out, logger.GetLevel())
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	logger.SetLevel(log.InfoLevel)
+
+	var out bytes.Buffer
+	r := &Reporter{label: "Uploading", out: &out}
+	for written := int64(0); written <= 1000; written += 10 {
+		r.Update(written, 1000)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no progress bar, got: %q", out.String())
+	}
+	if n := strings.Count(logs.String(), "Uploading:"); n != 1 {
+		t.Errorf("expected the progress to be logged once, got %d times:\n%s", n, logs.String())
+	}
+
+	r.Done()
+	if !strings.Contains(logs.String(), "Uploading: 100%") {
+		t.Errorf("expected the final progress to be logged, got:\n%s", logs.String())
+	}
+}